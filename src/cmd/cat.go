@@ -39,6 +39,6 @@ func main() {
   }
   fs := rfs.NewRFS(c)
   f, _ := fs.Open(name)
-  buf := buffer.FromFile(f, buffer.Config{})
+  buf, _ := buffer.FromFile(f, buffer.Config{})
   io.Copy(os.Stdout, buf)
 }
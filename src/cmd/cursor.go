@@ -47,7 +47,7 @@ func main() {
   }
   fs := rfs.NewRFS(c)
   f, _ := fs.Open(name)
-  buf := buffer.FromFile(f, buffer.Config{TabWidth: 8})
+  buf, _ := buffer.FromFile(f, buffer.Config{TabWidth: 8})
   cols, rows, err := term.GetSize(int(os.Stdin.Fd()))
   if err != nil {
     panic(err)
@@ -5,6 +5,15 @@ import (
   "../../src/pkg/rexec"
   "../../src/pkg/raster"
   "../../src/pkg/buffer"
+  "../../src/pkg/layout"
+  "../../src/pkg/plumb"
+  "../../src/pkg/quickfix"
+  "../../src/pkg/fuzzy"
+  "../../src/pkg/ed"
+  "../../src/pkg/register"
+  "../../src/pkg/syntax"
+  "../../src/pkg/lsp"
+  "../../src/pkg/journal"
   "os"
   "io"
   "io/ioutil"
@@ -12,148 +21,2367 @@ import (
   "fmt"
   "bufio"
   "bytes"
-//  "regexp"
+  "strings"
+  "strconv"
+  "sort"
+  "path"
+  "path/filepath"
+  "unicode"
+  "regexp"
+  "sync"
+  "time"
   "golang.org/x/crypto/ssh"
   "golang.org/x/term"
 )
 
 var oldState *term.State
-var client *ssh.Client
+var ras *raster.Raster
 
-func readLine(prompt string) string {
-  term.Restore(int(os.Stdin.Fd()), oldState)
-  _, rows, _ := term.GetSize(int(os.Stdin.Fd()))
-  fmt.Printf("\033[%d;1H\033[K\033[31m%s", rows + 1, prompt)
-  line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-  oldState, _ = term.MakeRaw(int(os.Stdin.Fd()))
-  return line
+// Keys typed, and work finished in the background, for the main loop to handle.
+var keys = make(chan rune)
+var updates = make(chan func())
+
+// What is being typed at the bottom of the screen, if anything.
+var prompt string
+
+// Drawn over the windows, if set.
+var overlay func(ras *raster.Raster)
+
+// Files in each project, keyed by host:root, as listed for the finder.
+var projectFiles = map[string][]string{}
+
+// The pattern last searched for with /, ? or :s.
+var lastSearch *regexp.Regexp
+var clients = map[string]*ssh.Client{}
+// held while dialing, as language servers are started in the background
+var dialing sync.Mutex
+var buffers bufferList
+var screen *layout.Layout
+var bookmarks = map[rune]*buffer.Marker{}
+var plumbing []plumb.Rule
+var grammars = syntax.Default
+
+// Language servers by host, project root and language. Ones that wouldn't
+// start are kept as nil, so that they aren't tried again for every key.
+var servers = map[string]*server{}
+
+// Files waiting for the language server that is being started for them, by
+// the same key as servers.
+var starting = map[string][]*entry{}
+
+// Copies of files with unsaved edits, kept locally in case ged dies. Not
+// kept when running a script.
+var journals *journal.Journal
+
+// A file's copy in the journal is brought up to date once this many edits
+// have piled up, or once the first of them has waited JOURNAL_WAIT, so that
+// the whole of a big file isn't written out for every key.
+const JOURNAL_EVERY = 100
+const JOURNAL_WAIT = 2 * time.Second
+
+// Set while journalEdits is waiting to run again, for edits made since it
+// last did.
+var journalWaiting bool
+
+// Settings changed with :set.
+var options = map[string]string{
+  "makeprg": "go build ./...",
+  "tabwidth": "8",
+  "textwidth": "79",
+}
+
+// Registers, the one keys are being recorded into, if any, and what has been
+// recorded so far.
+var registers *register.Registers
+var recording rune
+var recorded []rune
+
+// Keys from a macro, which nextKey hands out before reading any more, the
+// register last run with @, and how many macros have been run since a key
+// was last typed.
+var replay []rune
+var lastMacro rune
+var plays int
+
+// How many macros can run for one key typed, so that one that runs itself
+// without ever failing can't hang ged.
+const MAX_PLAYS = 10000
+
+// The last f, F, t or T, and the rune it looked for, for ; and , to repeat.
+var lastFind [2]rune
+
+// Set by :q to leave the main loop.
+var quitting bool
+
+// Where the last :make ran, and what it complained about.
+var fixes = &quickfix.List{}
+var fixHost, fixDir string
+var screenRows, screenCols int
+
+// Files named without a host are opened here.
+const defaultHost = "localhost"
+
+// An open file, where it came from, and the window it was last shown in.
+type entry struct {
+  host, path string
+  buf *buffer.Buffer
+  win *buffer.Window
+  kind int
+  dir string
+  count int
+  // whether a language server has been looked for, the one the file is open
+  // in, if any, its URI there, and the buffer version it last heard about
+  attached bool
+  server *server
+  uri string
+  synced int
+  // where the file is kept in the journal, the resolved path, or "" if it
+  // isn't journaled, and if that is because another ged has it, that ged's
+  // process ID, until the user has been told
+  journalPath string
+  owner int
+  // the buffer version last copied to the journal and when, and a copy left
+  // by an earlier session that the user hasn't recovered or thrown away yet
+  journaled int
+  journaledAt time.Time
+  recovery *journal.Copy
+}
+
+// A language server running on a remote host.
+type server struct {
+  client *lsp.Client
+  cmd *rexec.RCmd
+}
+
+// What an entry holds. Only FILEs can be saved.
+const (
+  FILE = iota
+  // search hits, as file:line: lines relative to dir
+  RESULTS
+  // the contents of the remote directory dir
+  LISTING
+)
+
+// All open files, in the order they were opened.
+type bufferList struct {
+  entries []*entry
+}
+
+func (e *entry) Name() string {
+  if e.kind == RESULTS {
+    return e.path
+  }
+  return e.host + ":" + e.path
+}
+
+func (e *entry) Modified() bool {
+  return e.kind == FILE && e.buf.Modified()
+}
+
+// Splits "host:path" into its parts. Plain paths are on the default host.
+func splitSpec(spec string) (host, path string) {
+  i := strings.Index(spec, ":")
+  if i < 0 || strings.Contains(spec[:i], "/") {
+    return defaultHost, spec
+  }
+  return spec[:i], spec[i + 1:]
+}
+
+// Returns a connection to host, dialing it the first time.
+func dial(host string) (*ssh.Client, error) {
+  dialing.Lock()
+  defer dialing.Unlock()
+  if c, ok := clients[host]; ok {
+    return c, nil
+  }
+	key, err := ioutil.ReadFile("/home/ryanne/.ssh/test")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %v", err)
+	}
+	// Create the Signer for this private key.
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+  config := ssh.ClientConfig{
+    User: "ryanne",
+		Auth: []ssh.AuthMethod{
+			// Use the PublicKeys method for remote authentication.
+			ssh.PublicKeys(signer),
+		},
+    HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+  }
+  c, err := ssh.Dial("tcp", host + ":22", &config)
+  if err != nil {
+    return nil, err
+  }
+  clients[host] = c
+  return c, nil
+}
+
+// The buffer shown in the focused window.
+func (l *bufferList) current() *entry {
+  return l.owner(screen.Focus())
+}
+
+// Finds the entry whose buffer w is a window on, or nil if it has been closed.
+func (l *bufferList) owner(w *buffer.Window) *entry {
+  for _, e := range l.entries {
+    if e.buf == w.Buffer() {
+      return e
+    }
+  }
+  return nil
+}
+
+// Opens spec, or finds it if it is already open.
+func (l *bufferList) open(spec string) (*entry, error) {
+  return l.load(spec, true)
+}
+
+// Opens spec like open, but only if it is there to be read, for when nobody
+// is watching to notice a typo or a failed read before the file is saved.
+func (l *bufferList) openExisting(spec string) (*entry, error) {
+  return l.load(spec, false)
+}
+
+// Opens spec, or finds it if it is already open. Files that can't be looked
+// at are new files if create is set.
+func (l *bufferList) load(spec string, create bool) (*entry, error) {
+  host, path := splitSpec(spec)
+  for _, e := range l.entries {
+    if e.host == host && e.path == path {
+      return e, nil
+    }
+  }
+  c, err := dial(host)
+  if err != nil {
+    return nil, err
+  }
+  f, err := rfs.NewRFS(c).Open(path)
+  if err != nil {
+    return nil, err
+  }
+  e := &entry{host: host, path: path}
+  // files that don't exist yet are new files, not errors
+  if info, err := f.Stat(); err == nil && info.IsDir() {
+    return l.openDir(host, path)
+  } else if err == nil {
+    if e.buf, err = buffer.FromFile(f, config()); err != nil {
+      return nil, fmt.Errorf("reading %s: %v", spec, err)
+    }
+  } else if create {
+    e.buf = &buffer.Buffer{Config: config()}
+  } else {
+    return nil, err
+  }
+  e.win = newWindow(e.buf, e.Name())
+  e.buf.MarkSaved()
+  e.buf.SetSyntax(syntax.Detect(grammars, path, strings.SplitN(e.buf.String(), "\n", 2)[0]))
+  e.showChanges()
+  if err := e.claim(); err != nil {
+    return nil, err
+  }
+  l.entries = append(l.entries, e)
+  return e, nil
+}
+
+func (l *bufferList) index(e *entry) int {
+  for i, v := range l.entries {
+    if v == e {
+      return i
+    }
+  }
+  return -1
+}
+
+// Adds an empty buffer that isn't backed by a file.
+func (l *bufferList) scratch(kind int, name, host, dir string) *entry {
+  e := &entry{host: host, path: name, dir: dir, kind: kind}
+  e.buf = &buffer.Buffer{Config: config()}
+  e.win = newWindow(e.buf, name)
+  l.entries = append(l.entries, e)
+  return e
+}
+
+// Opens a listing of a remote directory, or finds the one already open.
+func (l *bufferList) openDir(host, dir string) (*entry, error) {
+  dir = path.Clean(dir)
+  for _, e := range l.entries {
+    if e.kind == LISTING && e.host == host && e.dir == dir {
+      return e, nil
+    }
+  }
+  e := l.scratch(LISTING, strings.TrimSuffix(dir, "/") + "/", host, dir)
+  if err := e.list(); err != nil {
+    l.entries = l.entries[:len(l.entries) - 1]
+    e.win.Close()
+    return nil, err
+  }
+  return e, nil
+}
+
+// Fills a LISTING with the directory's entries, keeping cursors on the same lines.
+func (e *entry) list() error {
+  c, err := dial(e.host)
+  if err != nil {
+    return err
+  }
+  infos, err := rfs.NewRFS(c).ReadDir(e.dir)
+  if err != nil {
+    return err
+  }
+  lines := map[*buffer.Window]int{}
+  for _, w := range e.buf.Windows() {
+    lines[w], _ = w.Position()
+  }
+  e.buf.Clear()
+  e.buf.AppendLine(e.Name())
+  for _, d := range infos {
+    info, err := d.Info()
+    if err != nil {
+      continue
+    }
+    name := info.Name()
+    if info.IsDir() {
+      name += "/"
+    }
+    e.buf.AppendLine(fmt.Sprintf("%s %10d %s %s", info.Mode(), info.Size(),
+      info.ModTime().Format("2006-01-02 15:04"), name))
+  }
+  for w, line := range lines {
+    w.GoTo(line, 1)
+  }
+  return nil
+}
+
+// Picks the name out of a line of a LISTING.
+var listed = regexp.MustCompile(`^\S+\s+\d+\s+\S+\s+\S+\s(.+)$`)
+
+// The full path of the entry on the cursor's line of a LISTING.
+func listedPath(e *entry, w *buffer.Window) (string, error) {
+  m := listed.FindStringSubmatch(w.Line())
+  if m == nil {
+    return "", fmt.Errorf("no file on this line")
+  }
+  return path.Join(e.dir, m[1]), nil
+}
+
+// Enter on a directory descends into it, and on a file opens it.
+func enterListing(e *entry, w *buffer.Window) error {
+  p, err := listedPath(e, w)
+  if err != nil {
+    return err
+  }
+  target, err := buffers.open(e.host + ":" + p)
+  if err != nil {
+    return err
+  }
+  display(target)
+  return nil
+}
+
+// Creates, renames or deletes entries of a LISTING, then lists it again.
+func listingCommand(cmd, arg string) error {
+  e := buffers.current()
+  if e.kind != LISTING {
+    return fmt.Errorf("%s isn't a directory", e.Name())
+  }
+  c, err := dial(e.host)
+  if err != nil {
+    return err
+  }
+  fs := rfs.NewRFS(c)
+  switch (cmd) {
+  case "create":
+    if arg == "" {
+      return fmt.Errorf("usage: create name, or name/ for a directory")
+    }
+    if strings.HasSuffix(arg, "/") {
+      err = fs.Mkdir(path.Join(e.dir, arg))
+    } else {
+      err = fs.Create(path.Join(e.dir, arg))
+    }
+  case "rename":
+    if arg == "" {
+      return fmt.Errorf("usage: rename newname")
+    }
+    var p string
+    if p, err = listedPath(e, screen.Focus()); err == nil {
+      err = fs.Rename(p, path.Join(e.dir, arg))
+    }
+  case "delete":
+    var p string
+    if p, err = listedPath(e, screen.Focus()); err == nil {
+      if readLine("delete " + p + "? (y/n) ") == "y" {
+        err = fs.Remove(p)
+      }
+    }
+  }
+  if err != nil {
+    return err
+  }
+  return e.list()
+}
+
+// Goes up from a LISTING, or the directory a file is in.
+func parent() error {
+  e := buffers.current()
+  dir := path.Dir(e.path)
+  if e.kind == LISTING {
+    dir = path.Dir(e.dir)
+  } else if e.kind == RESULTS {
+    dir = e.dir
+  }
+  target, err := buffers.openDir(e.host, dir)
+  if err != nil {
+    return err
+  }
+  display(target)
+  return nil
+}
+
+// Finds a buffer by its number in the list, or by part of its name.
+func (l *bufferList) find(arg string) (int, error) {
+  if n, err := strconv.Atoi(arg); err == nil {
+    if n < 1 || n > len(l.entries) {
+      return 0, fmt.Errorf("no buffer %d", n)
+    }
+    return n - 1, nil
+  }
+  found := -1
+  for i, e := range l.entries {
+    if strings.Contains(e.Name(), arg) {
+      if found >= 0 {
+        return 0, fmt.Errorf("more than one buffer matches %s", arg)
+      }
+      found = i
+    }
+  }
+  if found < 0 {
+    return 0, fmt.Errorf("no buffer matches %s", arg)
+  }
+  return found, nil
+}
+
+func (l *bufferList) list() string {
+  var builder strings.Builder
+  for i, e := range l.entries {
+    cur, mod := ' ', ' '
+    if e == l.current() {
+      cur = '%'
+    }
+    if e.Modified() {
+      mod = '+'
+    }
+    fmt.Fprintf(&builder, "%3d %c%c %s\n", i + 1, cur, mod, e.Name())
+  }
+  return builder.String()
+}
+
+// Closes the i'th buffer and any windows on it. Unsaved changes are only thrown
+// away if force is set.
+func (l *bufferList) close(i int, force bool) error {
+  e := l.entries[i]
+  if !force && e.Modified() {
+    return fmt.Errorf("%s has unsaved changes (add ! to discard them)", e.Name())
+  }
+  l.entries = append(l.entries[:i], l.entries[i + 1:]...)
+  if e.server != nil {
+    e.server.client.Close(e.uri)
+  }
+  for _, w := range screen.Windows() {
+    if w.Buffer() != e.buf {
+      continue
+    }
+    screen.Select(w)
+    if !screen.Close() && len(l.entries) > 0 {
+      display(l.entries[0])
+    }
+  }
+  for _, w := range e.buf.Windows() {
+    w.Close()
+  }
+  for r, m := range bookmarks {
+    if m.Buffer() == e.buf {
+      m.Release()
+      delete(bookmarks, r)
+    }
+  }
+  return e.release()
+}
+
+// Remembers the cursor position under r.
+func setBookmark(w *buffer.Window, r rune) {
+  if m, ok := bookmarks[r]; ok {
+    m.Release()
+    delete(bookmarks, r)
+    showBookmarks(m.Buffer())
+  }
+  bookmarks[r] = w.NewMarker()
+  showBookmarks(w.Buffer())
+}
+
+// Puts each bookmark in b beside its line.
+func showBookmarks(b *buffer.Buffer) {
+  var signs []buffer.Sign
+  for r, m := range bookmarks {
+    if m.Buffer() == b {
+      signs = append(signs, buffer.Sign{At: m, Mark: r, Style: raster.INFO})
+    }
+  }
+  sort.Slice(signs, func(a, b int) bool {
+    return signs[a].Mark < signs[b].Mark
+  })
+  b.SetSigns("marks", signs)
+}
+
+// Goes back to the position remembered under r, in whichever buffer it was.
+func gotoBookmark(r rune) error {
+  m, ok := bookmarks[r]
+  if !ok {
+    return fmt.Errorf("no bookmark %c", r)
+  }
+  for _, e := range buffers.entries {
+    if e.buf == m.Buffer() {
+      display(e)
+    }
+  }
+  screen.Focus().Jump(m)
+  return nil
+}
+
+// A new window onto b, with the gutter the options ask for.
+func newWindow(b *buffer.Buffer, name string) *buffer.Window {
+  w := b.Window(name, screenRows, screenCols)
+  w.Gutter = gutter()
+  return w
+}
+
+// A window onto e that isn't already on screen.
+func windowFor(e *entry) *buffer.Window {
+  if screen != nil && screen.Visible(e.win) {
+    return newWindow(e.buf, e.Name())
+  }
+  return e.win
+}
+
+// Shows e in the focused window.
+func display(e *entry) {
+  old := screen.Focus()
+  if old.Buffer() == e.buf {
+    return
+  }
+  screen.Show(windowFor(e))
+  release(old)
+}
+
+// Closes a window that has gone off screen, unless its buffer is keeping it.
+func release(w *buffer.Window) {
+  if screen.Visible(w) {
+    return
+  }
+  if e := buffers.owner(w); e != nil && e.win == w {
+    return
+  }
+  w.Close()
+}
+
+// Splits the focused window, showing spec (or the current buffer) in the new half.
+func split(spec string, vertical bool) error {
+  e := buffers.current()
+  if spec != "" {
+    var err error
+    if e, err = buffers.open(spec); err != nil {
+      return err
+    }
+  }
+  if !screen.Split(windowFor(e), vertical) {
+    return fmt.Errorf("no room to split the window")
+  }
+  return nil
+}
+
+func closeWindow() error {
+  old := screen.Focus()
+  if !screen.Close() {
+    return fmt.Errorf("can't close the last window")
+  }
+  release(old)
+  return nil
+}
+
+// Handles the key after ^W.
+func windowCommand(rn rune) error {
+  switch (rn) {
+  case 's': return split("", false)
+  case 'v': return split("", true)
+  case 'c', 'q': return closeWindow()
+  case 'w', 0x17: screen.Next()
+  case 'h', 'j', 'k', 'l': screen.Move(rn)
+  default: beep()
+  }
+  return nil
+}
+
+func (e *entry) save() error {
+  if e.kind != FILE {
+    return fmt.Errorf("%s isn't a file", e.Name())
+  }
+  c, err := dial(e.host)
+  if err != nil {
+    return err
+  }
+  contents := e.buf.String()
+  if err := rfs.NewRFS(c).WriteFile(e.path, strings.NewReader(contents)); err != nil {
+    return err
+  }
+  e.buf.MarkSaved()
+  e.showChanges()
+  return e.forget()
+}
+
+// Matches the start of each hunk of a diff, with the lines it covers before
+// and after. Counts left out are 1.
+var hunk = regexp.MustCompile(`(?m)^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Marks the lines of a file that differ from its last commit, as saved, if
+// the gitsigns option is set. Files git doesn't know about have no marks.
+func (e *entry) showChanges() {
+  if e.kind != FILE || options["gitsigns"] != "true" {
+    e.buf.SetSigns("git", nil)
+    return
+  }
+  out, err := remoteRun(e.host, path.Dir(e.path), "git diff -U0 --no-color -- " + rfs.Quote(path.Base(e.path)))
+  if err != nil {
+    e.buf.SetSigns("git", nil)
+    return
+  }
+  count := func(s string) int {
+    if s == "" {
+      return 1
+    }
+    n, _ := strconv.Atoi(s)
+    return n
+  }
+  var signs []buffer.Sign
+  for _, m := range hunk.FindAllStringSubmatch(out, -1) {
+    removed, line, added := count(m[2]), count(m[3]), count(m[4])
+    if added == 0 {
+      // the lines went after line, or before the first if it is 0
+      sign := buffer.Sign{Line: line, Mark: '_', Style: raster.REMOVED}
+      if line == 0 {
+        sign.Line, sign.Mark = 1, '‾'
+      }
+      signs = append(signs, sign)
+      continue
+    }
+    for i := 0; i < added; i++ {
+      sign := buffer.Sign{Line: line + i, Mark: '+', Style: raster.ADDED}
+      if i < removed {
+        sign.Mark, sign.Style = '~', raster.CHANGED
+      }
+      signs = append(signs, sign)
+    }
+  }
+  e.buf.SetSigns("git", signs)
+}
+
+// Runs what was typed after ':'. Anything that isn't one of ged's own commands
+// goes to the remote shell, as does anything after '!'.
+func colon(w *buffer.Window, line string) {
+  if line == "" {
+    return
+  } else if strings.HasPrefix(line, "!") {
+    show(remoteShell(w, line[1:]).String())
+  } else if handled, err := command(line); err != nil {
+    showError(err)
+  } else if !handled {
+    show(remoteShell(w, line).String())
+  }
+}
+
+// Matches :s and :%s, followed by their delimiter and the rest.
+var substitution = regexp.MustCompile(`^(%?)s([^\w\s].*)$`)
+
+// Splits "/pat/repl/flags" at each unescaped delimiter, the delimiter being
+// whatever comes first.
+func splitSubstitute(arg string) (parts []string) {
+  runes := []rune(arg)
+  delim := runes[0]
+  var part []rune
+  for k := 1; k < len(runes); k++ {
+    if runes[k] == '\\' && k + 1 < len(runes) && runes[k + 1] == delim {
+      part = append(part, delim)
+      k++
+    } else if runes[k] == delim {
+      parts = append(parts, string(part))
+      part = nil
+    } else {
+      part = append(part, runes[k])
+    }
+  }
+  return append(parts, string(part))
+}
+
+// Handles "s/pat/repl/flags", on the selection or the cursor's line, or with
+// whole set, on all of the buffer. Flags are g to replace every match on a
+// line, c to confirm each one, and i to ignore case.
+func substitute(arg string, whole bool) error {
+  parts := splitSubstitute(arg)
+  if len(parts) < 2 || len(parts) > 3 {
+    return fmt.Errorf("usage: s/pattern/replacement/flags")
+  }
+  flags := ""
+  if len(parts) == 3 {
+    flags = parts[2]
+  }
+  prefix := "(?m)"
+  if strings.Contains(flags, "i") {
+    prefix = "(?mi)"
+  }
+  pat, err := regexp.Compile(prefix + parts[0])
+  if err != nil {
+    return err
+  }
+  lastSearch = pat
+  registers.SetSearch(parts[0])
+  var confirm func() rune
+  if strings.Contains(flags, "c") {
+    confirm = func() rune {
+      prompt = "replace with " + parts[1] + "? (y/n/a/q)"
+      defer func() {
+        prompt = ""
+      }()
+      redraw()
+      return nextKey()
+    }
+  }
+  w := screen.Focus()
+  if w.Substitute(pat, parts[1], whole, strings.Contains(flags, "g"), confirm) == 0 {
+    return fmt.Errorf("pattern not found: %s", parts[0])
+  }
+  return nil
+}
+
+// Handles / and ?, and n and N, which search again for the last pattern.
+func search(w *buffer.Window, rn rune) error {
+  if rn == '/' || rn == '?' {
+    line := readLine(string(rn))
+    if line == "" {
+      return nil
+    }
+    pat, err := regexp.Compile("(?m)" + line)
+    if err != nil {
+      return err
+    }
+    lastSearch = pat
+    registers.SetSearch(line)
+  }
+  if lastSearch == nil {
+    return fmt.Errorf("no previous search")
+  }
+  found := false
+  if rn == '/' || rn == 'n' {
+    found = w.Find(lastSearch)
+  } else {
+    found = w.FindReverse(lastSearch)
+  }
+  if !found {
+    return fmt.Errorf("pattern not found: %s", strings.TrimPrefix(lastSearch.String(), "(?m)"))
+  }
+  return nil
+}
+
+// Runs one of ged's own commands. Anything else is left for the remote shell.
+func command(line string) (handled bool, err error) {
+  if m := substitution.FindStringSubmatch(line); m != nil {
+    return true, substitute(m[2], m[1] == "%")
+  }
+  fields := strings.Fields(line)
+  if len(fields) == 0 {
+    return false, nil
+  }
+  arg := strings.Join(fields[1:], " ")
+  switch (fields[0]) {
+  case "e":
+    if arg == "" {
+      return true, fmt.Errorf("usage: e host:path")
+    }
+    e, err := buffers.open(arg)
+    if err == nil {
+      display(e)
+    }
+    return true, err
+  case "b":
+    i, err := buffers.find(arg)
+    if err == nil {
+      display(buffers.entries[i])
+    }
+    return true, err
+  case "ls":
+    show(buffers.list())
+    return true, nil
+  case "bd", "bd!":
+    i := buffers.index(buffers.current())
+    if arg != "" {
+      if i, err = buffers.find(arg); err != nil {
+        return true, err
+      }
+    }
+    return true, buffers.close(i, fields[0] == "bd!")
+  case "w":
+    return true, buffers.current().save()
+  case "sp", "split":
+    return true, split(arg, false)
+  case "vs", "vsplit":
+    return true, split(arg, true)
+  case "clo", "close":
+    return true, closeWindow()
+  case "set":
+    return true, set(arg)
+  case "make":
+    return true, runMake(arg)
+  case "cn", "cnext":
+    return true, gotoFix(fixes.Next())
+  case "cp", "cprev":
+    return true, gotoFix(fixes.Prev())
+  case "create", "rename", "delete":
+    return true, listingCommand(fields[0], arg)
+  case "grep":
+    return true, grep(arg)
+  case "diag":
+    return true, loadDiagnostics()
+  case "cl", "clist":
+    show(fixes.String())
+    return true, nil
+  case "reg", "registers":
+    show(registers.String())
+    return true, nil
+  case "q", "q!":
+    for _, e := range buffers.entries {
+      if fields[0] == "q" && e.Modified() {
+        return true, fmt.Errorf("%s has unsaved changes (add ! to quit anyway)", e.Name())
+      }
+    }
+    quitting = true
+    return true, nil
+  }
+  return false, nil
+}
+
+// Feeds keys from the terminal to nextKey.
+func readKeys() {
+  in := bufio.NewReader(os.Stdin)
+  for {
+    rn, _, err := in.ReadRune()
+    if err != nil {
+      close(keys)
+      return
+    }
+    keys <- rn
+  }
+}
+
+// Waits for a key, running any updates that arrive in the meantime. Keys
+// being replayed come first, and keys typed while recording are recorded.
+func nextKey() rune {
+  if len(replay) > 0 {
+    rn := replay[0]
+    replay = replay[1:]
+    return rn
+  }
+  return typedKey()
+}
+
+// Waits for a key from the terminal, whatever is being replayed.
+func typedKey() rune {
+  rn := terminalKey()
+  if recording != 0 {
+    recorded = append(recorded, rn)
+  }
+  return rn
+}
+
+// Waits for a key from the terminal like typedKey, but leaves it out of any
+// recording, for keys that only dismiss a message and would do something
+// else entirely when a macro is played back.
+func terminalKey() rune {
+  for {
+    select {
+    case rn, ok := <-keys:
+      if !ok {
+        panic("stdin closed")
+      }
+      plays = 0
+      return rn
+    case f := <-updates:
+      f()
+      redraw()
+    }
+  }
+}
+
+func redraw() {
+  screen.Render(ras, screenRows, screenCols)
+  if overlay != nil {
+    overlay(ras)
+  }
+  io.Copy(os.Stdout, ras)
+  if prompt != "" {
+    drawPrompt()
+  }
+}
+
+func drawPrompt() {
+  fmt.Printf("\033[%d;1H\033[K\033[31m%s\033[0m", screenRows + 1, prompt)
+}
+
+// Reads a line on the bottom row. ESC gives up and returns "".
+func readLine(p string) string {
+  return readLineFrom(p, nextKey)
+}
+
+// Reads a line like readLine, taking keys from next.
+func readLineFrom(p string, next func() rune) string {
+  var line []rune
+  defer func() {
+    prompt = ""
+  }()
+  for {
+    prompt = p + string(line)
+    drawPrompt()
+    switch rn := next(); rn {
+    case '\r', '\n':
+      return string(line)
+    case '\033':
+      return ""
+    case 8, 0x7F:
+      if len(line) > 0 {
+        line = line[:len(line) - 1]
+      }
+    case 0x15:
+      line = nil
+    default:
+      if unicode.IsGraphic(rn) {
+        line = append(line, rn)
+      }
+    }
+  }
+}
+
+func remoteShell(w *buffer.Window, line string) (buf *bytes.Buffer) {
+  buf = new(bytes.Buffer)
+  client, err := dial(buffers.owner(w).host)
+  if err != nil {
+    showError(err)
+    return
+  }
+  ros := rexec.NewROS(client)   
+  cmd, err := ros.Command(line)
+  if err != nil {
+    showError(err)
+    return
+  }
+  inf, _ := cmd.StdinPipe()
+  outf, _ := cmd.StdoutPipe()
+  errf, _ := cmd.StderrPipe()
+  if err := cmd.Start(); err != nil {
+    showError(err)
+    return
+  }
+  io.Copy(inf, w.NewReader())
+  inf.Close()
+  errs, _ := ioutil.ReadAll(errf)
+  if len(errs) > 0 {
+    showError(fmt.Errorf("%s", errs))
+    return
+  }
+  io.Copy(buf, outf)
+  if err := cmd.Wait(); err != nil {
+    showError(err)
+    return
+  }
+  return
+}
+
+// Runs line on host in dir, returning everything it printed.
+func remoteRun(host, dir, line string) (string, error) {
+  client, err := dial(host)
+  if err != nil {
+    return "", err
+  }
+  cmd, err := rexec.NewROS(client).Command(fmt.Sprintf("cd %s && %s", rfs.Quote(dir), line))
+  if err != nil {
+    return "", err
+  }
+  defer cmd.Close()
+  out, err := cmd.CombinedOutput()
+  return string(out), err
+}
+
+// Opens p, relative to dir, in the focused window at the given line and column.
+func openAt(host, dir, p string, line, col int) error {
+  if !path.IsAbs(p) {
+    p = path.Join(dir, p)
+  }
+  e, err := buffers.open(host + ":" + p)
+  if err != nil {
+    return err
+  }
+  display(e)
+  if line > 0 {
+    screen.Focus().GoTo(line, col)
+  }
+  return nil
+}
+
+// Splits ":grep" arguments into a pattern, which may be quoted, and a path.
+func grepArgs(arg string) (pattern, where string) {
+  end := strings.IndexAny(arg, " \t")
+  if len(arg) > 0 && (arg[0] == '"' || arg[0] == '\'') {
+    if i := strings.IndexByte(arg[1:], arg[0]); i >= 0 {
+      pattern, arg = arg[1:i + 1], arg[i + 2:]
+      end = -1
+    }
+  }
+  if end >= 0 {
+    pattern, arg = arg[:end], arg[end:]
+  } else if pattern == "" {
+    pattern, arg = arg, ""
+  }
+  where = strings.TrimSpace(arg)
+  if where == "" {
+    where = "."
+  }
+  return
+}
+
+// Searches the remote host with the best tool it has, streaming hits into a
+// results buffer as they arrive.
+func grep(arg string) error {
+  pattern, where := grepArgs(arg)
+  if pattern == "" {
+    return fmt.Errorf("usage: grep pattern [path]")
+  }
+  cur := buffers.current()
+  host, dir := cur.host, path.Dir(cur.path)
+  client, err := dial(host)
+  if err != nil {
+    return err
+  }
+  script := fmt.Sprintf("cd %[1]s && " +
+    "if command -v rg >/dev/null 2>&1; then rg -Hn --no-heading --color never -e %[2]s %[3]s; " +
+    "elif git rev-parse --is-inside-work-tree >/dev/null 2>&1; then git grep -n -e %[2]s -- %[3]s; " +
+    "else grep -rHn -e %[2]s %[3]s; fi", rfs.Quote(dir), rfs.Quote(pattern), rfs.Quote(where))
+  cmd, err := rexec.NewROS(client).Command(script)
+  if err != nil {
+    return err
+  }
+  out, err := cmd.StdoutPipe()
+  if err != nil {
+    cmd.Close()
+    return err
+  }
+  if err := cmd.Start(); err != nil {
+    cmd.Close()
+    return err
+  }
+  e := buffers.scratch(RESULTS, "[grep " + pattern + "]", host, dir)
+  display(e)
+  go func() {
+    defer cmd.Close()
+    scanner := bufio.NewScanner(out)
+    for scanner.Scan() {
+      line := scanner.Text()
+      updates <- func() {
+        e.buf.AppendLine(line)
+        if e.count == 0 {
+          for _, w := range e.buf.Windows() {
+            w.GoTo(1, 1)
+          }
+        }
+        e.count++
+      }
+    }
+    cmd.Wait()
+    updates <- func() {
+      e.path = fmt.Sprintf("[grep %s: %d hits]", pattern, e.count)
+    }
+  }()
+  return nil
+}
+
+// Lists the files in the project the current file is in: its git work tree,
+// or failing that its directory. The list is only fetched once per project.
+func listProject(e *entry, rescan bool) (root string, names []string, err error) {
+  dir := path.Dir(e.path)
+  if e.kind != FILE {
+    dir = e.dir
+  }
+  out, err := remoteRun(e.host, dir, "git rev-parse --show-toplevel 2>/dev/null || pwd")
+  if err != nil {
+    return "", nil, err
+  }
+  root = strings.TrimSpace(out)
+  key := e.host + ":" + root
+  if names, ok := projectFiles[key]; ok && !rescan {
+    return root, names, nil
+  }
+  out, err = remoteRun(e.host, root,
+    "git ls-files 2>/dev/null || find . -type f ! -path '*/.git/*' | sed 's|^\\./||'")
+  if err != nil {
+    return "", nil, err
+  }
+  // an empty listing, or a stray blank line, isn't a file to offer
+  names = nil
+  for _, name := range strings.Split(out, "\n") {
+    if name != "" {
+      names = append(names, name)
+    }
+  }
+  projectFiles[key] = names
+  return root, names, nil
+}
+
+// Fuzzy finds a file in the current project and opens it. ^R lists the
+// project again, in case files have come or gone.
+func findFile() error {
+  e := buffers.current()
+  root, names, err := listProject(e, false)
+  if err != nil {
+    return err
+  }
+  f := fuzzy.NewFinder(e.host + ":" + root, names)
+  overlay = func(ras *raster.Raster) {
+    rows, cols := screenRows * 2 / 3, screenCols * 3 / 4
+    f.Render(ras, (screenRows - rows) / 2, (screenCols - cols) / 2, rows, cols)
+  }
+  defer func() {
+    overlay = nil
+  }()
+  for {
+    redraw()
+    rn := nextKey()
+    if rn == 0x12 {
+      if root, names, err = listProject(e, true); err != nil {
+        return err
+      }
+      f = fuzzy.NewFinder(e.host + ":" + root, names)
+      continue
+    }
+    name, done := f.Key(rn)
+    if !done {
+      continue
+    }
+    if name == "" {
+      return nil
+    }
+    return openAt(e.host, root, name, 0, 0)
+  }
+}
+
+// Enter on a line of search results opens the hit; anywhere else it plumbs.
+func enter(w *buffer.Window) error {
+  e := buffers.owner(w)
+  if e.kind == LISTING {
+    return enterListing(e, w)
+  } else if e.kind != RESULTS {
+    return plumbAt(w)
+  }
+  f, ok := quickfix.ParseLine(w.Line())
+  if !ok {
+    return nil
+  }
+  return openAt(e.host, e.dir, f.Path, f.Line, f.Col)
+}
+
+// Runs makeprg next to the current file and loads what it complains about
+// into the quickfix list, jumping to the first complaint.
+func runMake(args string) error {
+  e := buffers.current()
+  fixHost, fixDir = e.host, path.Dir(e.path)
+  out, err := remoteRun(fixHost, fixDir, options["makeprg"] + " " + args)
+  fixes = quickfix.Parse(strings.NewReader(out))
+  if fixes.Len() == 0 {
+    if err != nil {
+      return fmt.Errorf("%s%v", out, err)
+    }
+    showMsg("no errors")
+    return nil
+  }
+  return gotoFix(fixes.Next())
+}
+
+func gotoFix(f quickfix.Entry, ok bool) error {
+  if !ok {
+    return fmt.Errorf("no errors")
+  }
+  if err := openAt(fixHost, fixDir, f.Path, f.Line, f.Col); err != nil {
+    return err
+  }
+  showMsg(f.Text)
+  return nil
+}
+
+// How buffers are set up, according to the options.
+func config() buffer.Config {
+  tab, _ := strconv.Atoi(options["tabwidth"])
+  text, _ := strconv.Atoi(options["textwidth"])
+  return buffer.Config{
+    TabWidth: tab,
+    ExpandTab: options["expandtab"] == "true",
+    AutoIndent: options["autoindent"] == "true",
+    TextWidth: text,
+  }
+}
+
+// What windows draw beside their text, according to the options.
+func gutter() buffer.Gutter {
+  var g buffer.Gutter
+  switch {
+  case options["number"] == "true" && options["relativenumber"] == "true": g.Numbers = buffer.HYBRID
+  case options["relativenumber"] == "true": g.Numbers = buffer.RELATIVE
+  case options["number"] == "true": g.Numbers = buffer.ABSOLUTE
+  }
+  switch (options["signcolumn"]) {
+  case "yes": g.Signs = 1
+  case "no": g.Signs = -1
+  }
+  return g
+}
+
+// Handles ":set name=value", ":set name" and ":set noname".
+func set(arg string) error {
+  if arg == "" {
+    var builder strings.Builder
+    for k, v := range options {
+      fmt.Fprintf(&builder, "%s=%s\n", k, v)
+    }
+    show(builder.String())
+    return nil
+  }
+  if i := strings.Index(arg, "="); i >= 0 {
+    key, value := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i + 1:])
+    // the syntax is the focused buffer's own
+    if key == "syntax" {
+      g := syntax.Find(grammars, value)
+      if g == nil && value != "off" {
+        return fmt.Errorf("no syntax %s", value)
+      }
+      buffers.current().buf.SetSyntax(g)
+      return nil
+    }
+    // folds are the focused window's own, and found once, when this is set
+    if key == "foldmethod" {
+      switch (value) {
+      case "indent": screen.Focus().FoldIndent()
+      case "bracket": screen.Focus().FoldBrackets()
+      case "manual":
+      default: return fmt.Errorf("foldmethod must be manual, indent or bracket")
+      }
+      return nil
+    }
+    if key == "signcolumn" && value != "auto" && value != "yes" && value != "no" {
+      return fmt.Errorf("signcolumn must be auto, yes or no")
+    }
+    if key == "tabwidth" || key == "textwidth" {
+      if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+        return fmt.Errorf("%s must be a positive number", key)
+      }
+    }
+    options[key] = value
+  } else if strings.HasPrefix(arg, "no") {
+    options[arg[2:]] = "false"
+  } else {
+    options[arg] = "true"
+  }
+  for _, e := range buffers.entries {
+    e.buf.Config = config()
+    for _, w := range e.buf.Windows() {
+      w.Gutter = gutter()
+    }
+    if arg == "gitsigns" || arg == "nogitsigns" {
+      e.showChanges()
+    }
+  }
+  return nil
+}
+
+// Runs each line of the startup file as if it were typed after ':'.
+func runConfig(name string) error {
+  contents, err := ioutil.ReadFile(name)
+  if os.IsNotExist(err) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  for n, line := range strings.Split(string(contents), "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    if handled, err := command(line); err != nil {
+      return fmt.Errorf("%s:%d: %v", name, n + 1, err)
+    } else if !handled {
+      return fmt.Errorf("%s:%d: unknown command", name, n + 1)
+    }
+  }
+  return nil
 }
 
-func remoteShell(w *buffer.Window, line string) (buf *bytes.Buffer) {
-  buf = new(bytes.Buffer)
-  ros := rexec.NewROS(client)   
-  cmd, err := ros.Command(line)
+// Acts on the text Enter was pressed over: opens files, shows URLs and runs
+// anything else remotely, from the directory of the file being edited.
+func plumbAt(w *buffer.Window) error {
+  text := w.Target()
+  if text == "" {
+    return nil
+  }
+  m, ok := plumb.Plumb(plumbing, text)
+  if !ok {
+    return nil
+  }
+  e := buffers.owner(w)
+  dir := path.Dir(e.path)
+  switch (m.Action) {
+  case plumb.OPEN:
+    return openAt(e.host, dir, m.Path, m.Line, m.Col)
+  case plumb.URL:
+    showMsg(m.Text)
+  case plumb.RUN:
+    out, err := remoteRun(e.host, dir, m.Text)
+    if err != nil {
+      return fmt.Errorf("%s%v", out, err)
+    }
+    show(out)
+  }
+  return nil
+}
+
+// Runs a sam-style command on the window's buffer, with dot starting at the
+// selection and ending up selected.
+func edit(w *buffer.Window, line string) error {
+  if line == "" {
+    return nil
+  }
+  var out strings.Builder
+  e := ed.New(w.Buffer(), &out)
+  q0, q1 := w.Dot()
+  e.SetDot(ed.Range{Q0: q0, Q1: q1})
+  err := e.Run(line)
+  dot := e.Dot()
+  w.SetDot(dot.Q0, dot.Q1)
+  if out.Len() > 0 {
+    show(out.String())
+  }
+  return err
+}
+
+// Starts command on host, in root, and a session with it.
+func startServer(host, root, command string) (*server, error) {
+  client, err := dial(host)
   if err != nil {
-    showError(err)
-    return
+    return nil, err
   }
-  inf, _ := cmd.StdinPipe()
-  outf, _ := cmd.StdoutPipe()
-  errf, _ := cmd.StderrPipe()
+  cmd, err := rexec.NewROS(client).Command(fmt.Sprintf("cd %s && exec %s", rfs.Quote(root), command))
+  if err != nil {
+    return nil, err
+  }
+  in, err := cmd.StdinPipe()
+  if err != nil {
+    cmd.Close()
+    return nil, err
+  }
+  out, err := cmd.StdoutPipe()
+  if err != nil {
+    cmd.Close()
+    return nil, err
+  }
+  // servers log to stderr, and stop if nothing reads it
+  cmd.Stderr = ioutil.Discard
   if err := cmd.Start(); err != nil {
-    showError(err)
-    return
+    cmd.Close()
+    return nil, err
   }
-  io.Copy(inf, w.NewReader())
-  inf.Close()
-  errs, _ := ioutil.ReadAll(errf)
-  if len(errs) > 0 {
-    showError(fmt.Errorf("%s", errs))
+  s := &server{cmd: cmd}
+  s.client = lsp.New(in, out, func(uri string) {
+    go func() {
+      updates <- func() {
+        showDiagnostics(s, uri)
+      }
+    }()
+  })
+  if err := s.client.Initialize(root); err != nil {
+    cmd.Close()
+    return nil, fmt.Errorf("%s: %v", command, err)
+  }
+  return s, nil
+}
+
+// Asks the language servers to exit, and hangs up on them.
+func stopServers() {
+  for _, s := range servers {
+    if s != nil {
+      s.client.Shutdown()
+      s.cmd.Close()
+    }
+  }
+}
+
+// Finds the project a file on host is in, and the file's full path there.
+func projectOf(host, file string) (root, full string, err error) {
+  out, err := remoteRun(host, path.Dir(file),
+    "pwd -P && (git rev-parse --show-toplevel 2>/dev/null || pwd -P)")
+  if err != nil {
+    return "", "", fmt.Errorf("%s%v", out, err)
+  }
+  lines := strings.Split(strings.TrimSpace(out), "\n")
+  if len(lines) != 2 {
+    return "", "", fmt.Errorf("can't tell what project %s:%s is in", host, file)
+  }
+  return lines[1], path.Join(lines[0], path.Base(file)), nil
+}
+
+// Opens a file in the language server for its syntax, as set with
+// ":set lsp.go=gopls", starting the server if it isn't running yet. Finding
+// the project and starting the server both wait on the remote host, so they
+// are done in the background, and the file is opened in the server through
+// updates once they're done.
+func attach(e *entry) {
+  g := e.buf.Syntax()
+  if e.kind != FILE || g == nil || options["lsp." + g.Name] == "" {
     return
   }
-  io.Copy(buf, outf)
-  if err := cmd.Wait(); err != nil {
-    showError(err)
+  e.attached = true
+  host, file, command := e.host, e.path, options["lsp." + g.Name]
+  go func() {
+    root, full, err := projectOf(host, file)
+    updates <- func() {
+      if err != nil {
+        showError(err)
+        return
+      }
+      e.uri = lsp.URI(full)
+      key := host + ":" + root + ":" + g.Name
+      if s, ok := servers[key]; ok {
+        if err := e.open(s, g.Name); err != nil {
+          showError(err)
+        }
+        return
+      }
+      waiting, ok := starting[key]
+      starting[key] = append(waiting, e)
+      if !ok {
+        go startFor(key, host, root, command, g.Name)
+      }
+    }
+  }()
+}
+
+// Starts the language server for key, then opens the files waiting for it.
+func startFor(key, host, root, command, language string) {
+  s, err := startServer(host, root, command)
+  updates <- func() {
+    servers[key] = s
+    waiting := starting[key]
+    delete(starting, key)
+    if err != nil {
+      showError(err)
+      return
+    }
+    for _, e := range waiting {
+      if err := e.open(s, language); err != nil {
+        showError(err)
+      }
+    }
+  }
+}
+
+// Opens the file in s, unless s wouldn't start or the file has been closed
+// while s was being found.
+func (e *entry) open(s *server, language string) error {
+  if s == nil || buffers.index(e) < 0 {
+    return nil
+  }
+  e.server, e.synced = s, e.buf.Version()
+  return s.client.Open(e.uri, language, e.buf.String())
+}
+
+// Sends the buffer to its language server, if it has changed since it last did.
+func (e *entry) sync() error {
+  if e.server == nil || e.synced == e.buf.Version() {
+    return nil
+  }
+  e.synced = e.buf.Version()
+  if err := e.server.client.Change(e.uri, e.buf.String()); err != nil {
+    e.server = nil
+    return fmt.Errorf("%s: %v", e.Name(), err)
+  }
+  return nil
+}
+
+// Claims the file's place in the journal, under its path with links and
+// relative parts resolved, and reads any copy an earlier session left there.
+// If another ged has the claim, the file isn't journaled here.
+func (e *entry) claim() error {
+  if journals == nil {
+    return nil
+  }
+  real := e.path
+  if out, err := remoteRun(e.host, ".", "realpath -m -- " + rfs.Quote(e.path)); err == nil {
+    real = strings.TrimSpace(out)
+  }
+  owner, err := journals.Claim(e.host, real)
+  if err != nil || owner != 0 {
+    e.owner = owner
+    return err
+  }
+  e.journalPath = real
+  e.recovery, err = journals.Read(e.host, real)
+  return err
+}
+
+// Copies files with edits that haven't been copied yet to the journal, or
+// if all isn't set, those with JOURNAL_EVERY edits to copy or that haven't
+// been copied for JOURNAL_WAIT. The rest are copied once the wait is up,
+// whether or not any more keys are typed. Files with a copy from an earlier
+// session waiting to be recovered are left alone, so as not to write over it.
+func journalEdits(all bool) error {
+  if journals == nil {
+    return nil
+  }
+  for _, e := range buffers.entries {
+    v := e.buf.Version()
+    if e.journalPath == "" || !e.Modified() || e.recovery != nil || v == e.journaled {
+      continue
+    }
+    if !all && v - e.journaled < JOURNAL_EVERY && time.Since(e.journaledAt) < JOURNAL_WAIT {
+      journalLater()
+      continue
+    }
+    if err := journals.Write(e.host, e.journalPath, e.buf.String()); err != nil {
+      journals = nil
+      return fmt.Errorf("%v; edits are no longer being journaled", err)
+    }
+    e.journaled, e.journaledAt = v, time.Now()
+  }
+  return nil
+}
+
+// Runs journalEdits again once JOURNAL_WAIT is up, unless it already will.
+func journalLater() {
+  if journalWaiting {
     return
   }
+  journalWaiting = true
+  time.AfterFunc(JOURNAL_WAIT, func() {
+    updates <- func() {
+      journalWaiting = false
+      if err := journalEdits(false); err != nil {
+        showError(err)
+      }
+    }
+  })
+}
+
+// Throws away the file's copy in the journal, as it is saved or its edits
+// are being given up, unless the copy is from an earlier session and hasn't
+// been looked at yet.
+func (e *entry) forget() error {
+  if journals == nil || e.journalPath == "" || e.recovery != nil {
+    return nil
+  }
+  e.journaled = e.buf.Version()
+  return journals.Remove(e.host, e.journalPath)
+}
+
+// Forgets the file's copy in the journal, as it is closed, and gives up the
+// claim on it for the next ged to open it.
+func (e *entry) release() error {
+  if err := e.forget(); err != nil || journals == nil || e.journalPath == "" {
+    return err
+  }
+  return journals.Release(e.host, e.journalPath)
+}
+
+// Asks whether to recover the edits journaled by an earlier session in each
+// file just opened, putting them in place of what was read, or else throwing
+// them away, and says which files another ged is journaling instead. Returns
+// whether any were recovered.
+func checkJournals() bool {
+  recovered := false
+  for _, e := range buffers.entries {
+    if e.owner != 0 {
+      showMsg(fmt.Sprintf("%s is open in another ged (process %d), so edits to it here aren't journaled",
+        e.Name(), e.owner))
+      e.owner = 0
+    }
+    c := e.recovery
+    if c == nil {
+      continue
+    }
+    answer := readLine(fmt.Sprintf("%s has unsaved edits from %s; recover them? (y/n) ",
+      e.Name(), c.Time.Format("2006-01-02 15:04")))
+    e.recovery = nil
+    if !strings.HasPrefix(answer, "y") {
+      if err := e.forget(); err != nil {
+        showError(err)
+      }
+      continue
+    }
+    lines := map[*buffer.Window]int{}
+    for _, w := range e.buf.Windows() {
+      lines[w], _ = w.Position()
+    }
+    e.buf.Clear()
+    e.buf.AppendString(c.Contents)
+    for w, line := range lines {
+      w.GoTo(line, 1)
+    }
+    recovered = true
+  }
+  return recovered
+}
+
+// Starts language servers for files that want one, and tells them about
+// edits. Edits made while inserting wait until insert mode is left, so that
+// the whole file isn't sent for every key.
+func syncServers(inserting bool) {
+  for _, e := range buffers.entries {
+    var err error
+    if !e.attached {
+      attach(e)
+    } else if !inserting {
+      err = e.sync()
+    }
+    if err != nil {
+      showError(err)
+    }
+  }
+}
+
+// Puts a language server's diagnostics for uri beside the lines they are
+// about, errors first, so that they're the ones drawn.
+func showDiagnostics(s *server, uri string) {
+  var signs []buffer.Sign
+  for _, d := range s.client.Diagnostics(uri) {
+    sign := buffer.Sign{Line: d.Range.Start.Line + 1, Mark: 'E', Style: raster.ERROR, Text: d.Message}
+    switch (d.Severity) {
+    case lsp.WARNING: sign.Mark, sign.Style = 'W', raster.WARNING
+    case lsp.INFORMATION, lsp.HINT: sign.Mark, sign.Style = 'I', raster.INFO
+    }
+    signs = append(signs, sign)
+  }
+  sort.SliceStable(signs, func(a, b int) bool {
+    return signs[a].Style < signs[b].Style
+  })
+  for _, e := range buffers.entries {
+    if e.server == s && e.uri == uri {
+      e.buf.SetSigns("diagnostics", signs)
+    }
+  }
+}
+
+// Loads the language server's complaints about the current file into the
+// quickfix list, for :cn and :cp to step through.
+func loadDiagnostics() error {
+  e := buffers.current()
+  if e.server == nil {
+    return fmt.Errorf("no language server for %s", e.Name())
+  }
+  var entries []quickfix.Entry
+  for _, d := range e.server.client.Diagnostics(e.uri) {
+    // columns count UTF-16 code units, which on most lines are runes
+    entries = append(entries, quickfix.Entry{Path: path.Base(e.path), Line: d.Range.Start.Line + 1,
+      Col: d.Range.Start.Character + 1, Text: d.Message})
+  }
+  fixes = quickfix.New(entries)
+  fixHost, fixDir = e.host, path.Dir(e.path)
+  if fixes.Len() == 0 {
+    showMsg("no diagnostics")
+    return nil
+  }
+  show(fixes.String())
+  return nil
+}
+
+// The entry for w's buffer, brought up to date in its language server.
+func served(w *buffer.Window) (*entry, error) {
+  e := buffers.owner(w)
+  if e.server == nil {
+    return nil, fmt.Errorf("no language server for %s", e.Name())
+  }
+  return e, e.sync()
+}
+
+// Where the cursor is, as language servers count.
+func cursorPosition(w *buffer.Window) lsp.Position {
+  line, col := w.Position()
+  return lsp.Position{Line: line - 1, Character: lsp.UTF16(w.Line(), col - 1)}
+}
+
+// Shows any diagnostics on the cursor's line, and what the language server
+// says about what is under the cursor.
+func hover(w *buffer.Window) error {
+  var text []string
+  line, _ := w.Position()
+  for _, s := range w.Buffer().SignsAt(line) {
+    if s.Text != "" {
+      text = append(text, s.Text)
+    }
+  }
+  e, err := served(w)
+  if err == nil {
+    var h string
+    if h, err = e.server.client.Hover(e.uri, cursorPosition(w)); h != "" {
+      text = append(text, h)
+    }
+  }
+  if len(text) == 0 && err != nil {
+    return err
+  } else if len(text) == 0 {
+    return fmt.Errorf("nothing to say about this")
+  }
+  show(strings.Join(text, "\n\n"))
+  return nil
+}
+
+// Goes to where what is under the cursor is defined, opening the file it's in.
+func definition(w *buffer.Window) error {
+  e, err := served(w)
+  if err != nil {
+    return err
+  }
+  locations, err := e.server.client.Definition(e.uri, cursorPosition(w))
+  if err != nil {
+    return err
+  }
+  if len(locations) == 0 {
+    return fmt.Errorf("no definition found")
+  }
+  l := locations[0]
+  p := lsp.Path(l.URI)
+  if p == "" {
+    return fmt.Errorf("can't open %s", l.URI)
+  }
+  if err := openAt(e.host, "/", p, l.Range.Start.Line + 1, 1); err != nil {
+    return err
+  }
+  w = screen.Focus()
+  w.GoTo(l.Range.Start.Line + 1, lsp.Column(w.Line(), l.Range.Start.Character) + 1)
+  return nil
+}
+
+// Offers what the language server thinks could be typed at the cursor, and
+// puts in the one picked, in place of the word it completes.
+func complete(w *buffer.Window) error {
+  e, err := served(w)
+  if err != nil {
+    return err
+  }
+  pos := cursorPosition(w)
+  items, err := e.server.client.Completion(e.uri, pos)
+  if err != nil {
+    return err
+  }
+  if len(items) == 0 {
+    return fmt.Errorf("no completions")
+  }
+  var labels []string
+  byLabel := map[string]lsp.Item{}
+  for _, item := range items {
+    if _, ok := byLabel[item.Label]; !ok {
+      labels = append(labels, item.Label)
+      byLabel[item.Label] = item
+    }
+  }
+  f := fuzzy.NewFinder("complete", labels)
+  overlay = func(ras *raster.Raster) {
+    rows, cols := screenRows * 2 / 3, screenCols * 3 / 4
+    f.Render(ras, (screenRows - rows) / 2, (screenCols - cols) / 2, rows, cols)
+  }
+  defer func() {
+    overlay = nil
+  }()
+  var label string
+  for done := false; !done; {
+    redraw()
+    label, done = f.Key(nextKey())
+  }
+  if label == "" {
+    return nil
+  }
+  item := byLabel[label]
+  line := []rune(w.Line())
+  col := lsp.Column(string(line), pos.Character)
+  start, text := col, item.InsertText
+  if item.TextEdit != nil {
+    start, text = lsp.Column(string(line), item.TextEdit.Range.Start.Character), item.TextEdit.NewText
+  } else {
+    for start > 0 && (unicode.IsLetter(line[start - 1]) || unicode.IsDigit(line[start - 1]) || line[start - 1] == '_') {
+      start--
+    }
+  }
+  if text == "" {
+    text = item.Label
+  }
+  for k := start; k < col; k++ {
+    w.Backspace()
+  }
+  w.InsertString(text)
+  return nil
+}
+
+// Starts recording keys into the register named by the next key, or stops
+// recording, saving what was typed up to the q that stopped it.
+func record() error {
+  if recording != 0 {
+    if len(recorded) > 0 {
+      recorded = recorded[:len(recorded) - 1]
+    }
+    err := registers.Set(recording, string(recorded))
+    recording = 0
+    return err
+  }
+  name := nextKey()
+  if !register.Writable(name) {
+    return fmt.Errorf("no register %q", name)
+  }
+  recording, recorded = name, nil
+  return nil
+}
+
+// Replays the keys in the register named by the next key, count times. @@
+// repeats the last one.
+func play(count int) error {
+  name := nextKey()
+  if name == '@' {
+    name = lastMacro
+  }
+  if !register.Valid(name) {
+    return fmt.Errorf("no register %q", name)
+  }
+  lastMacro = name
+  if plays++; plays > MAX_PLAYS {
+    replay = nil
+    return fmt.Errorf("@%c ran more than %d macros; is it running itself?", name, MAX_PLAYS)
+  }
+  macro := []rune(registers.Get(name))
+  var keys []rune
+  for k := 0; k < count || k == 0; k++ {
+    keys = append(keys, macro...)
+  }
+  replay = append(keys, replay...)
+  return nil
+}
+
+// Keeps text that was yanked, or deleted if del is set, in register name, or
+// the default ones if name is 0. Yanks also go to the terminal's clipboard
+// with :set clipboard.
+func keep(name rune, text string, del bool) error {
+  if del {
+    if err := registers.Delete(name, text); err != nil {
+      return err
+    }
+  } else if err := registers.Yank(name, text); err != nil {
+    return err
+  }
+  if options["clipboard"] == "true" && name != register.CLIPBOARD {
+    registers.Set(register.CLIPBOARD, text)
+  }
+  return nil
+}
+
+// The motion key rn stands for, how much of the text it covers for an
+// operator, and how many times to make it for count. Reads the rest of
+// motions longer than a key. G and gg go to line count if there is one, so
+// they are made once whatever the count.
+func motion(w *buffer.Window, rn rune, count int) (move func(), kind, times int, ok bool) {
+  move, kind, ok = motionKey(w, rn, count)
+  times = count
+  if rn == 'G' || rn == 'g' || times == 0 {
+    times = 1
+  }
   return
 }
 
+func motionKey(w *buffer.Window, rn rune, count int) (move func(), kind int, ok bool) {
+  switch (rn) {
+  case 'h': return w.Left, buffer.EXCLUSIVE, true
+  case 'l': return w.Right, buffer.EXCLUSIVE, true
+  case 'j': return w.Down, buffer.LINEWISE, true
+  case 'k': return w.Up, buffer.LINEWISE, true
+  case '0': return func() { w.Home() }, buffer.EXCLUSIVE, true
+  case '$': return func() { w.End() }, buffer.INCLUSIVE, true
+  case '^': return w.FirstNonBlank, buffer.EXCLUSIVE, true
+  case 'w', 'W': return func() { w.WordForward(rn == 'W') }, buffer.EXCLUSIVE, true
+  case 'b', 'B': return func() { w.WordBack(rn == 'B') }, buffer.EXCLUSIVE, true
+  case 'e', 'E': return func() { w.WordEnd(rn == 'E') }, buffer.INCLUSIVE, true
+  case '}': return w.ParagraphNext, buffer.EXCLUSIVE, true
+  case '{': return w.ParagraphPrev, buffer.EXCLUSIVE, true
+  case '%': return func() { w.MatchBracket() }, buffer.INCLUSIVE, true
+  case 'G', 'g':
+    if rn == 'g' && nextKey() != 'g' {
+      return nil, 0, false
+    }
+    return func() {
+      if count > 0 {
+        w.GoTo(count, 1)
+      } else if rn == 'g' {
+        w.DocStart()
+      } else {
+        w.DocEnd()
+      }
+      w.FirstNonBlank()
+    }, buffer.LINEWISE, true
+  case 'f', 'F', 't', 'T':
+    lastFind = [2]rune{rn, nextKey()}
+    rn = ';'
+    fallthrough
+  case ';', ',':
+    forward := lastFind[0] == 'f' || lastFind[0] == 't'
+    if rn == ',' {
+      forward = !forward
+    }
+    till := lastFind[0] == 't' || lastFind[0] == 'T'
+    kind := buffer.INCLUSIVE
+    if !forward {
+      kind = buffer.EXCLUSIVE
+    }
+    return func() { w.FindRune(lastFind[1], forward, till) }, kind, lastFind[0] != 0
+  }
+  return nil, 0, false
+}
+
+// Applies operator op, keeping what it deletes or copies in register name. In
+// visual mode it applies to the selection, and otherwise to the text covered
+// by the motion or text object typed next, taken count times, or to count
+// lines if the operator is typed twice. Returns false if it was cancelled.
+func operate(w *buffer.Window, op rune, count int, name rune, visual bool) (bool, error) {
+  r := w.Selection()
+  if !visual {
+    n, rn := readCount(nextKey())
+    if n > 0 && count > 0 {
+      count *= n
+    } else if n > 0 {
+      count = n
+    }
+    // cw changes just the word, like ce
+    if op == 'c' && (rn == 'w' || rn == 'W') {
+      rn += 'e' - 'w'
+    }
+    if rn == op {
+      r = w.Lines(count)
+    } else if rn == 'i' || rn == 'a' {
+      var ok bool
+      if r, ok = w.Object(nextKey(), rn == 'i'); !ok {
+        beep()
+        return false, nil
+      }
+    } else if rn == 'w' || rn == 'W' {
+      r = w.Words(count, rn == 'W')
+    } else if move, kind, times, ok := motion(w, rn, count); ok {
+      r = w.Motion(move, times, kind)
+    } else {
+      if rn != '\033' {
+        beep()
+      }
+      return false, nil
+    }
+  }
+  text := w.Apply(op, r)
+  if op == 'd' || op == 'c' || op == 'y' {
+    return true, keep(name, text, op != 'y')
+  }
+  return true, nil
+}
+
+// Handles z followed by rn, but for zf, which is an operator: zF folds count
+// lines, zo, zc and za open, close and toggle the fold at the cursor, zR and
+// zM open and close all of them, and zd deletes the fold at the cursor and zE
+// all of them. Returns false if there was nothing to do.
+func foldCommand(w *buffer.Window, rn rune, count int) bool {
+  switch (rn) {
+  case 'F': w.Fold(w.Lines(count))
+  case 'o': return w.OpenFold()
+  case 'c': return w.CloseFold()
+  case 'a': return w.ToggleFold()
+  case 'R': w.OpenAll(true)
+  case 'M': w.OpenAll(false)
+  case 'd': return w.DeleteFold()
+  case 'E': w.ClearFolds()
+  default: return false
+  }
+  return true
+}
+
+// Puts rn back for nextKey to read again.
+func unread(rn rune) {
+  replay = append([]rune{rn}, replay...)
+}
+
+// Reads a count typed before a command, returning it and the key after it.
+// Without a count, returns 0 and rn.
+func readCount(rn rune) (count int, next rune) {
+  for rn >= '1' && rn <= '9' || count > 0 && rn == '0' {
+    count = count * 10 + int(rn - '0')
+    rn = nextKey()
+  }
+  return count, rn
+}
+
+// Shows what went wrong until a key is typed. Any macro being replayed stops
+// there, rather than running on from the wrong place.
 func showError(err error) {
-  readLine(fmt.Sprintf("%s", err))
+  replay = nil
+  showMsg(fmt.Sprintf("%s", err))
 }
 
+// Shows s until a key is typed. The key is read from the terminal, so that
+// messages shown while a macro runs don't eat its keys, and isn't recorded,
+// as the message may not come up when the macro is played.
 func showMsg(s string) {
-  readLine(s)
+  readLineFrom(s, terminalKey)
+}
+
+// Complains about a key that couldn't be acted on, stopping any macro being
+// replayed like showError.
+func beep() {
+  replay = nil
+  fmt.Print("\a")
 }
 
 func show(s string) {
   // TODO spawn `more` pager
+  s = strings.TrimRight(s, "\n")
+  if strings.Contains(s, "\n") {
+    // the next frame redraws over all of this
+    fmt.Print("\033[H\033[2J" + strings.ReplaceAll(s, "\n", "\r\n") + "\r\n")
+    s = ""
+  }
   showMsg(s)
 }
 
+// Runs script on each of the named files, without a terminal, saving the
+// ones it changes. What the script prints goes to stdout. Returns false if
+// any file couldn't be opened, edited or saved.
+func batch(script string, names []string) bool {
+  ok := true
+  for _, name := range names {
+    e, err := buffers.openExisting(name)
+    if err == nil && e.kind != FILE {
+      err = fmt.Errorf("not a file")
+    }
+    if err == nil {
+      err = ed.New(e.buf, os.Stdout).RunScript(script)
+    }
+    if err == nil && e.Modified() {
+      err = e.save()
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+      ok = false
+    }
+  }
+  return ok
+}
+
 func main() {
-  name := "/proc/cpuinfo"
+  names := []string{"/proc/cpuinfo"}
   if len(os.Args) > 1 {
-    name = os.Args[1]
+    names = os.Args[1:]
   }
-	key, err := ioutil.ReadFile("/home/ryanne/.ssh/test")
-	if err != nil {
-		log.Fatalf("unable to read private key: %v", err)
-	}
-	// Create the Signer for this private key.
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		log.Fatalf("unable to parse private key: %v", err)
-	}
-  config := ssh.ClientConfig{
-    User: "ryanne",
-		Auth: []ssh.AuthMethod{
-			// Use the PublicKeys method for remote authentication.
-			ssh.PublicKeys(signer),
-		},
-    HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+  // ged -s script file..., where a script of - is read from stdin
+  if len(names) > 0 && names[0] == "-s" {
+    if len(names) < 3 {
+      log.Fatal("usage: ged -s script file...")
+    }
+    var script []byte
+    var err error
+    if names[1] == "-" {
+      script, err = ioutil.ReadAll(os.Stdin)
+    } else {
+      script, err = ioutil.ReadFile(names[1])
+    }
+    if err != nil {
+      log.Fatal(err)
+    }
+    if !batch(string(script), names[2:]) {
+      os.Exit(1)
+    }
+    return
   }
-  c, err := ssh.Dial("tcp", "localhost:22", &config)
-  client = c
+  cols, rows, err := term.GetSize(int(os.Stdin.Fd()))
   if err != nil {
     panic(err)
   }
-  fs := rfs.NewRFS(c)
-  f, _ := fs.Open(name)
-  buf := buffer.FromFile(f, buffer.Config{TabWidth: 8})
-  cols, rows, err := term.GetSize(int(os.Stdin.Fd()))
+  // leave the bottom line for prompts
+  screenRows, screenCols = rows - 1, cols
+  configDir, err := os.UserConfigDir()
   if err != nil {
-    panic(err)
+    log.Fatal(err)
+  }
+  if plumbing, err = plumb.Load(filepath.Join(configDir, "ged", "plumbing")); err != nil {
+    log.Fatal(err)
+  }
+  // a bad registers file is complained about once the screen is up, rather
+  // than keeping ged from starting
+  var registersErr error
+  registers, registersErr = register.Load(filepath.Join(configDir, "ged", "registers"))
+  registers.Clipboard = os.Stdout
+  // written once on the way out, crashes included, rather than on every change
+  defer func() {
+    if err := registers.Save(); err != nil {
+      log.Print(err)
+    }
+  }()
+  if journals, err = journal.Open(filepath.Join(configDir, "ged", "journal")); err != nil {
+    log.Fatal(err)
+  }
+  defer stopServers()
+  if grammars, err = syntax.Load(filepath.Join(configDir, "ged", "syntax")); err != nil {
+    log.Fatal(err)
+  }
+  for _, name := range names {
+    if _, err := buffers.open(name); err != nil {
+      log.Fatalf("unable to open %s: %v", name, err)
+    }
+  }
+  screen = layout.New(buffers.entries[0].win)
+  screen.Status = func(w *buffer.Window) string {
+    e := buffers.owner(w)
+    status := e.Name()
+    if e.Modified() {
+      status += " [+]"
+    }
+    if recording != 0 && w == screen.Focus() {
+      status += " recording @" + string(recording)
+    }
+    return status
   }
-  ras := raster.New(rows, cols)
+  if err := runConfig(filepath.Join(configDir, "ged", "gedrc")); err != nil {
+    log.Fatal(err)
+  }
+  ras = raster.New(rows, cols)
   oldState, err = term.MakeRaw(int(os.Stdin.Fd()))
   if err != nil {
     panic(err)
   }
   defer term.Restore(int(os.Stdin.Fd()), oldState)
-  in := bufio.NewReader(os.Stdin)
-  w := buf.Window(name, 25, 80)
+  // whatever ged dies of, the edits it had are kept
+  defer func() {
+    if r := recover(); r != nil {
+      journalEdits(true)
+      panic(r)
+    }
+  }()
+  go readKeys()
+  if registersErr != nil {
+    redraw()
+    showError(fmt.Errorf("reading registers: %v", registersErr))
+  }
   mode := 'x'
-  clip := ""
-  for {
-    w.Render(ras)
-    io.Copy(os.Stdout, ras)
-    rn, _, err := in.ReadRune()
-    if err != nil {
-      panic(err)
+  for len(buffers.entries) > 0 && !quitting {
+    w := screen.Focus()
+    syncServers(mode == 'i' || mode == 'o')
+    if err := journalEdits(false); err != nil {
+      showError(err)
+    }
+    ras.ClearRect(screenRows, 0, 1, screenCols)
+    redraw()
+    if checkJournals() {
+      redraw()
+    }
+    rn := nextKey()
+    count := 0
+    // the register named with ", if any
+    name := rune(0)
+    if mode != 'i' && mode != 'o' {
+      count, rn = readCount(rn)
+      if rn == '"' {
+        name = nextKey()
+        var n int
+        if n, rn = readCount(nextKey()); n > 0 {
+          count = n
+        }
+      }
     }
-    if rn == ':' {
-      show(remoteShell(w, readLine(":")).String())
-    } else if rn == '>' {
-      w.InsertString(remoteShell(w, readLine(">")).String())
-    } else if rn == '\033' {
+    // everything one key does undoes together, as does everything typed in
+    // insert mode
+    b := w.Buffer()
+    b.BeginChange()
+    if rn == '\033' {
+      if mode == 'i' || mode == 'o' {
+        w.EndBlockInsert()
+        b.EndChange()
+      }
       mode = 'x'
       w.ClearMark()
+    } else if mode == 'i' && rn == 0x0E {
+      if err := complete(w); err != nil {
+        showError(err)
+      }
     } else if mode == 'i' {
       w.Insert(rn)
     } else if mode == 'o' {
       w.Overwrite(rn)
+    } else if rn == ':' {
+      colon(w, strings.TrimSpace(readLine(":")))
+    } else if rn == '!' {
+      // > inserted remote output before it became the shift operator
+      w.InsertString(remoteShell(w, readLine("!")).String())
+    } else if rn == 'Q' {
+      if err := edit(w, strings.TrimSpace(readLine("Q "))); err != nil {
+        showError(err)
+      }
     } else {
       switch (rn) {
-      case 'i': mode = 'i'
-      case 'o': mode = 'o'
+      case 'i', 'a':
+        if mode != 'v' && rn == 'i' {
+          mode = 'i'
+          b.BeginChange()
+        } else if mode != 'v' {
+          beep()
+        } else if r, ok := w.Object(nextKey(), rn == 'i'); ok {
+          w.Select(r)
+        } else {
+          beep()
+        }
+      case 'o': mode = 'o'; b.BeginChange(); w.BeginOverwrite()
       case 'v': mode = 'v'; w.Mark()
-      case 'h': w.Left()
-      case 'j': w.Down()
-      case 'k': w.Up()
-      case 'l': w.Right()
-      case 'x','d': w.Delete()
-      case '0': w.Home()
-      case '$': w.End()
-      case 'A': w.End(); mode = 'i'
-      case 13:  w.Plumb()
-      case 'y': clip = w.Yank()
-      case 'p': w.InsertString(clip)
-      case 'q': return
-      case '\033': return
-      default: fmt.Print("\a")
+      case 'V': mode = 'v'; w.MarkLines()
+      case 0x16: mode = 'v'; w.MarkBlock()
+      case 'x':
+        text := ""
+        if mode == 'v' {
+          text = w.Apply('d', w.Selection())
+        } else if count > 1 {
+          text = w.Apply('d', w.Motion(w.RightOnLine, count, buffer.EXCLUSIVE))
+        } else {
+          text = w.Delete()
+        }
+        if err := keep(name, text, true); err != nil {
+          showError(err)
+        }
+        mode = 'x'
+      case 'g':
+        // gq reflows, gd goes to a definition, and anything else starting
+        // with g is a motion
+        if rn = nextKey(); rn == 'd' {
+          if err := definition(w); err != nil {
+            showError(err)
+          }
+          break
+        } else if rn != 'q' {
+          unread(rn)
+          rn = 'g'
+          move, _, _, ok := motion(w, rn, count)
+          if !ok {
+            beep()
+            break
+          }
+          move()
+          break
+        }
+        fallthrough
+      case 'd', 'y', 'c', '>', '<':
+        ok, err := operate(w, rn, count, name, mode == 'v')
+        if err != nil {
+          showError(err)
+        }
+        mode = 'x'
+        if ok && rn == 'c' {
+          mode = 'i'
+          b.BeginChange()
+        }
+      case 'A', 'I':
+        if mode == 'v' && w.Selection().Kind == buffer.BLOCKWISE {
+          w.BlockInsert(rn == 'A')
+        } else if rn == 'A' {
+          w.End()
+        } else {
+          w.FirstNonBlank()
+        }
+        mode = 'i'
+        b.BeginChange()
+      case 'z':
+        if rn = nextKey(); rn == 'f' {
+          if _, err := operate(w, 'z', count, name, mode == 'v'); err != nil {
+            showError(err)
+          }
+          mode = 'x'
+        } else if !foldCommand(w, rn, count) {
+          beep()
+        }
+      case 'K':
+        if err := hover(w); err != nil {
+          showError(err)
+        }
+      case 'u': w.Undo()
+      case 0x12: w.Redo()
+      case '/', '?', 'n', 'N':
+        if err := search(w, rn); err != nil {
+          showError(err)
+        }
+      case 13:
+        if err := enter(w); err != nil {
+          showError(err)
+        }
+      case 'p':
+        if name == 0 {
+          name = register.UNNAMED
+        }
+        if !register.Valid(name) {
+          showError(fmt.Errorf("no register %q", name))
+          break
+        }
+        for k := 0; k < count || k == 0; k++ {
+          w.InsertString(registers.Get(name))
+        }
+      case 0x10:
+        if err := findFile(); err != nil {
+          showError(err)
+        }
+      case '-':
+        if err := parent(); err != nil {
+          showError(err)
+        }
+      case 'm':
+        rn = nextKey()
+        setBookmark(w, rn)
+      case '\'':
+        rn = nextKey()
+        if err := gotoBookmark(rn); err != nil {
+          showError(err)
+        }
+      case 0x17:
+        rn = nextKey()
+        if err := windowCommand(rn); err != nil {
+          showError(err)
+        }
+      case 'q':
+        if err := record(); err != nil {
+          showError(err)
+        }
+      case '@':
+        if err := play(count); err != nil {
+          showError(err)
+        }
+      default:
+        move, _, times, ok := motion(w, rn, count)
+        if !ok {
+          beep()
+          break
+        }
+        for k := 0; k < times; k++ {
+          move()
+        }
       }
     }
+    b.EndChange()
+  }
+  // quitting gives up whatever wasn't saved
+  for _, e := range buffers.entries {
+    e.release()
   }
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+  "../../src/pkg/buffer"
+  "testing"
+)
+
+// Keys typed to dismiss a message aren't part of a macro, and a message
+// shown while one plays is dismissed from the terminal, not by its keys.
+func TestMacroAcrossMessage(t *testing.T) {
+  go func() {
+    for _, rn := range ":\r\rq" {
+      keys <- rn
+    }
+  }()
+  recording, recorded = 'a', nil
+  nextKey()
+  nextKey()
+  showMsg("a message")
+  nextKey()
+  recording = 0
+  if got := string(recorded); got != ":\rq" {
+    t.Errorf("recorded %q, want %q", got, ":\rq")
+  }
+
+  replay = []rune(":\r")
+  go func() {
+    keys <- '\r'
+  }()
+  showMsg("a message")
+  if got := string(replay); got != ":\r" {
+    t.Errorf("left %q to replay, want %q", got, ":\r")
+  }
+  replay = nil
+}
+
+// G goes to line count in one move, where other motions are made count times.
+func TestMotionTimes(t *testing.T) {
+  b := &buffer.Buffer{}
+  b.AppendString("a\nb\nc\n")
+  w := b.Window("test", 10, 80)
+  for _, test := range []struct {
+    rn rune
+    count, times int
+  }{
+    {'G', 50000, 1},
+    {'G', 0, 1},
+    {'j', 3, 3},
+    {'j', 0, 1},
+  } {
+    if _, _, times, ok := motion(w, test.rn, test.count); !ok || times != test.times {
+      t.Errorf("%d%c is made %d times, want %d", test.count, test.rn, times, test.times)
+    }
+  }
+}
@@ -42,7 +42,7 @@ func main() {
   }
   fs := rfs.NewRFS(c)
   f, _ := fs.Open(name)
-  buf := buffer.FromFile(f, buffer.Config{})
+  buf, _ := buffer.FromFile(f, buffer.Config{})
   ras := raster.New(25, 80)
   oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
   if err != nil {
@@ -0,0 +1,219 @@
+package buffer
+
+import (
+  "../raster"
+  "strings"
+)
+
+// Where text typed on the first line of a block goes on the lines after it:
+// at column col of each, or if pad is set, of each padded out to col.
+type blockInsert struct {
+  lines, col int
+  pad bool
+  // where the typing started, and how long the buffer was then
+  q0, n int
+}
+
+func (b *Buffer) tabWidth() int {
+  if b.Config.TabWidth <= 0 {
+    return 8
+  }
+  return b.Config.TabWidth
+}
+
+// How many columns c takes up when it starts at column col.
+func (b *Buffer) width(c rune, col int) int {
+  if c == '\t' {
+    return b.tabWidth() - col % b.tabWidth()
+  }
+  return raster.Width(c)
+}
+
+// The column p starts at.
+func (b *Buffer) column(p *node) (col int) {
+  start := p
+  for start.prev != nil && !EOL(start.prev.c) {
+    start = start.prev
+  }
+  for q := start; q != p; q = q.next {
+    col += b.width(q.c, col)
+  }
+  return
+}
+
+// The first and last columns of the block r.
+func (b *Buffer) columns(r Range) (left, right int) {
+  left, right = b.column(r.first), b.column(r.last)
+  leftEnd := left + b.width(r.first.c, left) - 1
+  rightEnd := right + b.width(r.last.c, right) - 1
+  if right < left {
+    left, right = right, left
+  }
+  if leftEnd > rightEnd {
+    rightEnd = leftEnd
+  }
+  return left, rightEnd
+}
+
+// The start of a line of a block, and the first and last runes of it within
+// the block's columns, which are nil if the line is too short.
+type piece struct {
+  start, first, last *node
+}
+
+func (b *Buffer) pieces(r Range) (pieces []piece) {
+  left, right := b.columns(r)
+  lines := b.lines(r.first, r.last)
+  for start := lines.first; start != nil; {
+    pc := piece{start: start}
+    col := 0
+    for p := start; p != nil && !EOL(p.c) && col <= right; p = p.next {
+      width := b.width(p.c, col)
+      if col + width - 1 >= left {
+        if pc.first == nil {
+          pc.first = p
+        }
+        pc.last = p
+      }
+      col += width
+    }
+    pieces = append(pieces, pc)
+    end := start
+    for end.next != nil && !EOL(end.c) {
+      end = end.next
+    }
+    if end == lines.last || end.next == nil {
+      break
+    }
+    start = end.next
+  }
+  return
+}
+
+// Where to insert at column col of the line p is on: the first rune at or
+// past col, or the end of the line. If the line is shorter, it is padded out
+// with spaces if pad is set, or false is returned.
+func (b *Buffer) at(p *node, col int, pad bool) (*node, bool) {
+  for p != nil && p.prev != nil && !EOL(p.prev.c) {
+    p = p.prev
+  }
+  j := 0
+  for ; p != nil && !EOL(p.c) && j < col; p = p.next {
+    j += b.width(p.c, j)
+  }
+  if j < col && !pad {
+    return nil, false
+  }
+  for ; j < col; j++ {
+    b.insertBefore(p, ' ')
+  }
+  return p, true
+}
+
+// Yanks, deletes or changes each line of a block, returning the pieces one
+// to a line. Changing a block starts an insert that goes on every line of it.
+func (w *Window) applyBlock(op rune, r Range) string {
+  pieces := w.buffer.pieces(r)
+  left, _ := w.buffer.columns(r)
+  var lines []string
+  for _, pc := range pieces {
+    var builder strings.Builder
+    for p := pc.first; pc.first != nil; p = p.next {
+      builder.WriteRune(p.c)
+      if p == pc.last {
+        break
+      }
+    }
+    lines = append(lines, builder.String())
+  }
+  w.mark = nil
+  top, _ := w.buffer.at(pieces[0].start, left, false)
+  if top == nil {
+    top = pieces[0].start
+  }
+  if op == 'y' {
+    w.cur = top
+    return strings.Join(lines, "\n")
+  }
+  q := w.buffer.offsetOf(top)
+  for _, pc := range pieces {
+    for p := pc.first; pc.first != nil; {
+      next := p.next
+      w.buffer.remove(p)
+      if p == pc.last {
+        break
+      }
+      p = next
+    }
+  }
+  w.cur = w.buffer.nodeAt(q)
+  if op == 'c' {
+    w.beginBlock(len(pieces) - 1, left, false)
+  }
+  return strings.Join(lines, "\n")
+}
+
+func (w *Window) beginBlock(lines, col int, pad bool) {
+  w.block = &blockInsert{lines, col, pad, w.buffer.offsetOf(w.cur), w.buffer.Len()}
+}
+
+// Starts inserting before the selected block, or after it, so that what is
+// typed on its first line goes on all of them when EndBlockInsert is called.
+// Lines too short for the block are left alone when inserting before it, and
+// padded out when appending after it.
+func (w *Window) BlockInsert(after bool) {
+  r := w.Selection()
+  if r.Kind != BLOCKWISE || r.Empty() {
+    return
+  }
+  left, right := w.buffer.columns(r)
+  pieces := w.buffer.pieces(r)
+  col := left
+  if after {
+    col = right + 1
+  }
+  w.mark = nil
+  w.cur, _ = w.buffer.at(pieces[0].start, col, true)
+  w.beginBlock(len(pieces) - 1, col, after)
+}
+
+// Copies what was typed since BlockInsert, or since changing a block, to the
+// rest of the block's lines, unless it runs onto another line.
+func (w *Window) EndBlockInsert() {
+  block := w.block
+  w.block = nil
+  if block == nil {
+    return
+  }
+  n := w.buffer.Len() - block.n
+  if n <= 0 {
+    return
+  }
+  first := w.buffer.nodeAt(block.q0)
+  var builder strings.Builder
+  for p, k := first, 0; p != nil && k < n; p, k = p.next, k + 1 {
+    builder.WriteRune(p.c)
+  }
+  text := builder.String()
+  if strings.ContainsRune(text, '\n') {
+    return
+  }
+  line := first
+  for k := 0; k < block.lines; k++ {
+    for line.next != nil && !EOL(line.c) {
+      line = line.next
+    }
+    if line.next == nil {
+      break
+    }
+    line = line.next
+    p, ok := w.buffer.at(line, block.col, block.pad)
+    if !ok {
+      continue
+    }
+    for _, c := range text {
+      w.buffer.insertBefore(p, c)
+    }
+  }
+  w.cur = first
+}
@@ -7,8 +7,9 @@ import (
 //  "bufio"
   "strings"
   "../raster"
-  "regexp"
+  "../syntax"
   "bytes"
+  "unicode"
 //  "fmt"
 )
 
@@ -19,20 +20,54 @@ type node struct {
 
 type Config struct {
   TabWidth int
+  // indent with spaces rather than tabs
+  ExpandTab bool
+  // start new lines with the indentation of the line before
+  AutoIndent bool
+  // how long Reflow makes lines, 79 if not set
+  TextWidth int
 }
 
 type Buffer struct {
   head, tail *node
   Config Config
   pending bytes.Buffer
+  windows []*Window
+  anchors map[**node]bool
+  undo, redo []change
+  change change
+  depth int
+  highlight *highlighter
+  // counts edits, and those that change where lines start or end
+  version, breaks int
+  // the node last found by its offset, to look for the next one from
+  near near
+  // the version last saved
+  saved int
+  // signs to draw beside lines, by who put them there
+  signs map[string][]Sign
 }
 
 type Window struct {
   Name string
   buffer *Buffer
   rows, cols int
+  offi, offj int
   curi, curj int
   top, cur, mark *node
+  // how the text between mark and cur is selected: INCLUSIVE, LINEWISE or
+  // BLOCKWISE
+  markKind int
+  // set while text typed on the first line of a block goes on the rest
+  block *blockInsert
+  // what each rune typed in overwrite mode replaced, or -1 where it was added
+  overwritten []rune
+  folds []*fold
+  // where the closed folds are, if known
+  folded *foldIndex
+  Gutter Gutter
+  numbered numbered
+  topSelected topSelected
 }
 
 type Reader struct {
@@ -44,10 +79,12 @@ func EOL(c rune) bool {
   return c == '\n'
 }
 
-func FromFile(f fs.File, config Config) *Buffer {
+// Reads all of f. If that fails, what was read before it did is returned
+// along with the error.
+func FromFile(f fs.File, config Config) (*Buffer, error) {
   b := &Buffer{Config: config}
-  io.Copy(b, f)
-  return b
+  _, err := io.Copy(b, f)
+  return b, err
 }
 
 func (b *Buffer) Read(p []byte) (int, error) {
@@ -78,10 +115,140 @@ func (b *Buffer) Write(p []byte) (int, error) {
 
 func (b *Buffer) Clear() {
   b.head, b.tail = nil, nil
+  b.version++
+  b.breaks++
+  for a := range b.anchors {
+    *a = nil
+  }
+  // the nodes undo would link back in are gone
+  b.undo, b.redo, b.change = nil, nil, nil
+  b.SetSyntax(b.Syntax())
 }
 
 func (b *Buffer) Window(name string, rows, cols int) *Window {
-  return &Window{Name: name, buffer: b, top: b.head, cur: b.head, rows: rows, cols: cols}
+  w := &Window{Name: name, buffer: b, top: b.head, cur: b.head, rows: rows, cols: cols}
+  b.windows = append(b.windows, w)
+  b.anchor(&w.top)
+  b.anchor(&w.cur)
+  b.anchor(&w.mark)
+  return w
+}
+
+// Detaches the window from its buffer. The window must not be used afterwards.
+func (w *Window) Close() {
+  w.ClearFolds()
+  w.buffer.unanchor(&w.top)
+  w.buffer.unanchor(&w.cur)
+  w.buffer.unanchor(&w.mark)
+  for i, v := range w.buffer.windows {
+    if v == w {
+      w.buffer.windows = append(w.buffer.windows[:i], w.buffer.windows[i + 1:]...)
+      return
+    }
+  }
+}
+
+// Changes whenever the buffer is edited, so callers can tell whether it has
+// been since they last looked.
+func (b *Buffer) Version() int {
+  return b.version
+}
+
+// Whether the buffer has been edited since MarkSaved was last called. Undoing
+// edits doesn't make it unmodified again.
+func (b *Buffer) Modified() bool {
+  return b.version != b.saved
+}
+
+// Records that the buffer, as it is now, is saved.
+func (b *Buffer) MarkSaved() {
+  b.saved = b.version
+}
+
+// All windows open on the buffer.
+func (b *Buffer) Windows() []*Window {
+  return append([]*Window(nil), b.windows...)
+}
+
+func (w *Window) Buffer() *Buffer {
+  return w.buffer
+}
+
+// Places the window at row i, column j of the raster it renders to.
+func (w *Window) Resize(i, j, rows, cols int) {
+  w.offi, w.offj = i, j
+  w.rows, w.cols = rows, cols
+}
+
+// Links a new rune in before p, or at the end if p is nil.
+func (b *Buffer) insertBefore(p *node, c rune) *node {
+  n := &node{c: c, prev: b.tail, next: p}
+  if p != nil {
+    n.prev = p.prev
+  }
+  b.link(n)
+  b.record(edit{n, true})
+  return n
+}
+
+// Unlinks p, moving any anchored positions on it to a neighbour.
+func (b *Buffer) remove(p *node) {
+  if p == nil {
+    return
+  }
+  b.unlink(p)
+  b.record(edit{p, false})
+}
+
+// Links n in between its prev and next, which must be neighbours.
+func (b *Buffer) link(n *node) {
+  if n.prev != nil {
+    n.prev.next = n
+  } else {
+    b.head = n
+  }
+  if n.next != nil {
+    n.next.prev = n
+  } else {
+    b.tail = n
+  }
+  b.version++
+  if EOL(n.c) || n.prev == nil || EOL(n.prev.c) {
+    b.breaks++
+  }
+  b.touched(n)
+  if n.prev == nil || EOL(n.prev.c) {
+    // n starts a line now, so windows starting at that line start at n
+    for _, w := range b.windows {
+      if w.top == n.next {
+        w.top = n
+      }
+    }
+  }
+}
+
+// Unlinks p, leaving its own prev and next alone so that it can be linked again.
+func (b *Buffer) unlink(p *node) {
+  b.version++
+  if EOL(p.c) || p.prev == nil || EOL(p.prev.c) {
+    b.breaks++
+  }
+  b.touched(p)
+  to := p.delete()
+  if to == nil {
+    to = p.prev
+  }
+  if p == b.head {
+    b.head = p.next
+  }
+  if p == b.tail {
+    b.tail = p.prev
+  }
+  for a := range b.anchors {
+    if *a == p {
+      *a = to
+    }
+  }
 }
 
 func (w *Window) Write(p []byte) (int, error) {
@@ -99,11 +266,17 @@ func (w *Window) Write(p []byte) (int, error) {
   }
 }
 
+// Reads the whole buffer without consuming it.
+func (b *Buffer) NewReader() *Reader {
+  return &Reader{head: b.head}
+}
+
 func (w *Window) NewReader() *Reader {
-  first, last := w.buffer.head, w.buffer.tail
+  first, last := w.buffer.head, (*node)(nil)
   if w.mark != nil {
-    first, last = w.marked()
-  }    
+    r := w.Selection()
+    first, last = r.first, r.last.next
+  }
   return &Reader{head: first, tail: last}
 }
 
@@ -130,6 +303,11 @@ func (b *Buffer) Append(c rune) {
     b.head = p
   }
   b.tail = p
+  b.version++
+  if EOL(c) || p.prev == nil || EOL(p.prev.c) {
+    b.breaks++
+  }
+  b.touched(p)
 }
 
 func (b *Buffer) AppendString(s string) {
@@ -154,12 +332,12 @@ func (b *Buffer) WriteString(s string) (int, error) {
   return len(s), nil
 }
 
-func (b Buffer) String() string {
+func (b *Buffer) String() string {
   var builder strings.Builder
   for pos := b.head; pos != nil; pos = pos.next {
     builder.WriteRune(pos.c)
   }
-  return b.String()
+  return builder.String()
 }
 
 // Insert a rune at the cursor's position.
@@ -167,22 +345,7 @@ func (w *Window) Insert(c rune) {
   if w.handleKeys(c) {
     return
   }
-  p := &node{c: c}
-  if w.cur == nil {
-    w.cur = w.buffer.tail
-  }
-  if w.cur == w.buffer.tail {
-    w.buffer.Append(c)
-  } else if w.cur == w.buffer.head {
-    //w.buffer.Prepend(c)
-  } else {
-    p.next = w.cur
-    p.prev = w.cur.prev
-    if w.cur.prev != nil {
-      w.cur.prev.next = p
-    }
-    w.cur.prev = p
-  }
+  w.buffer.insertBefore(w.cur, c)
 }
 
 func (w *Window) InsertString(s string) {
@@ -196,47 +359,117 @@ func (w *Window) InsertString(s string) {
   } 
 }
 
+// Delete the rune before the cursor.
 func (w *Window) Backspace() {
-  if w.cur.prev != nil {
-    w.cur.prev.next = w.cur.next
-  }
-  if w.cur.next != nil {
-    w.cur.next.prev = w.cur.prev
+  if w.cur == nil {
+    w.buffer.remove(w.buffer.tail)
+  } else {
+    w.buffer.remove(w.cur.prev)
   }
-  w.cur = w.cur.prev
 }
 
+// Starts over in overwrite mode, forgetting what was typed over before.
+func (w *Window) BeginOverwrite() {
+  w.overwritten = nil
+}
+
+// Replace the rune at the cursor's position and move past it, or add to the
+// end of the line. Backspace puts back what was replaced.
 func (w *Window) Overwrite(c rune) {
-  if w.handleKeys(c) {
+  switch (c) {
+  case 8, 0x7F:
+    w.restore()
+  case '\r':
+    w.buffer.insertBefore(w.cur, '\n')
+    w.overwritten = append(w.overwritten, -1)
+  default:
+    if w.cur == nil || EOL(w.cur.c) {
+      w.buffer.insertBefore(w.cur, c)
+      w.overwritten = append(w.overwritten, -1)
+      return
+    }
+    // removing the buffer's last rune leaves the cursor on the one before
+    old, next := w.cur.c, w.cur.next
+    w.buffer.remove(w.cur)
+    w.buffer.insertBefore(next, c)
+    w.cur = next
+    w.overwritten = append(w.overwritten, old)
+  }
+}
+
+// Undoes the last rune typed in overwrite mode, or moves left if there isn't
+// one.
+func (w *Window) restore() {
+  if len(w.overwritten) == 0 {
+    w.Left()
+    return
+  }
+  old := w.overwritten[len(w.overwritten) - 1]
+  w.overwritten = w.overwritten[:len(w.overwritten) - 1]
+  p := w.buffer.tail
+  if w.cur != nil {
+    p = w.cur.prev
+  }
+  if p == nil {
     return
   }
+  next := p.next
+  w.buffer.remove(p)
+  if old >= 0 {
+    w.cur = w.buffer.insertBefore(next, old)
+  }
 }
 
 func (w *Window) handleKeys(c rune) bool {
   switch (c) {
-  case '\r': w.Insert('\n')
+  case '\r': w.newline()
   case 8, 0x7F: w.Backspace()
   default: return false
   }
   return true
 }
 
+// The mark and the cursor, whichever comes first first. Searches out from the
+// cursor both ways at once, so nearby marks are found quickly.
 func (w *Window) marked() (first, last *node) {
   if w.mark == nil {
     return
-  }
-  for pos := w.buffer.head; pos != nil; pos = pos.next {
-    if pos == w.cur || pos == w.mark {
-      if first == nil {
-        first = pos
-      } else if last == nil {
-        last = pos
-      } else {
-        break
+  } else if w.mark == w.cur {
+    return w.cur, w.cur
+  } else if w.cur == nil {
+    return w.mark, nil
+  }
+  for next, prev := w.cur, w.cur; next != nil || prev != nil; {
+    if next != nil {
+      if next = next.next; next == w.mark {
+        return w.cur, w.mark
+      }
+    }
+    if prev != nil {
+      if prev = prev.prev; prev == w.mark {
+        return w.mark, w.cur
       }
     }
   }
-  return
+  return w.cur, w.mark
+}
+
+// Whether the top of a window is in a range, as of a version of the buffer.
+type topSelected struct {
+  version int
+  top, first, last *node
+  selected bool
+}
+
+// Whether the top of the window is in r, which is only worked out again when
+// the window scrolls or r or the text changes, and not on every render.
+func (w *Window) topInside(r Range) bool {
+  c := w.topSelected
+  if c.version != w.buffer.version || c.top != w.top || c.first != r.first || c.last != r.last {
+    selected := r.first == w.top || before(r.first, w.top) && !before(r.last, w.top)
+    w.topSelected = topSelected{w.buffer.version, w.top, r.first, r.last, selected}
+  }
+  return w.topSelected.selected
 }
 
 func (w *Window) forMarked(f func(p *node)) {
@@ -246,66 +479,189 @@ func (w *Window) forMarked(f func(p *node)) {
   }
 }
 
-// Delete the rune at the cursor's position.
-func (w *Window) Delete() {
-  w.forMarked(func(p *node) {
-    p.delete()
+// Deletes the selection, or the rune at the cursor's position, and returns
+// what was deleted.
+func (w *Window) Delete() string {
+  var builder strings.Builder
+  w.forMarked(func (p *node) {
+    builder.WriteRune(p.c)
+    w.buffer.remove(p)
   })
-  w.cur = w.cur.delete()
+  if w.cur != nil {
+    builder.WriteRune(w.cur.c)
+    w.buffer.remove(w.cur)
+  }
   w.mark = nil
+  return builder.String()
 }
 
 func (w *Window) Render(ras *raster.Raster) {
   i := 0
   j := 0
-  markDown := false
+  ras.ClearRect(w.offi, w.offj, w.rows, w.cols)
+  // the gutter takes columns off the left of the text
+  signCols, numberCols := w.gutterWidth(0)
+  line := 0
+  if signCols + numberCols > 0 {
+    line = w.topLine()
+    signCols, numberCols = w.gutterWidth(line)
+  }
+  offj, cols := w.offj + signCols + numberCols, w.cols - signCols - numberCols
+  // the line drawn on each row, where it starts, and the cursor's line
+  lines, starts := make([]int, w.rows), make([]*node, w.rows)
+  curLine := 0
+  if w.cur == nil {
+    ras.Cursor(w.offi, offj)
+  }
+  // what is selected, and whether the top of the window is in it already
+  var r Range
+  var left, right int
+  if w.mark != nil {
+    r = w.Selection()
+    if r.Kind == BLOCKWISE && !r.Empty() {
+      left, right = w.buffer.columns(r)
+      r = w.buffer.lines(r.first, r.last)
+      r.Kind = BLOCKWISE
+    }
+  }
+  selected := !r.Empty() && w.topInside(r)
+  tab := w.buffer.tabWidth()
+  // the syntax styles of the line being drawn, and which rune of it we're on
+  var styles []raster.Style
+  var state syntax.State
+  k := 0
+  if w.buffer.highlight != nil && w.top != nil {
+    state = w.buffer.stateAt(w.top)
+  }
+  var folded *foldIndex
+  if len(w.folds) > 0 {
+    folded = w.foldIndex()
+  }
   for pos := w.top; pos != nil && i < w.rows; pos = pos.next {
-    if pos == w.mark {
-      markDown = !markDown
+    if pos == w.top || EOL(pos.prev.c) {
+      if pos != w.top {
+        line++
+      }
+      lines[i], starts[i] = line, pos
+      if folded != nil && folded.starts[pos] != nil {
+        // a closed fold takes up one row, with the cursor at its start if
+        // it's anywhere inside
+        f := folded.starts[pos]
+        w.summarize(ras, f, i, offj, cols)
+        end := f.last
+        for end.next != nil && !EOL(end.c) {
+          end = end.next
+        }
+        for ; ; pos = pos.next {
+          if pos == r.first {
+            selected = true
+          }
+          if pos == w.cur {
+            w.curi, w.curj = i, 0
+            ras.Cursor(w.offi + i, offj)
+            curLine = line
+          }
+          if pos == r.last {
+            selected = false
+          }
+          if pos == end {
+            break
+          }
+        }
+        i++
+        line += f.lines - 1
+        if w.buffer.highlight != nil && end.next != nil {
+          state = w.buffer.stateAt(end.next)
+        }
+        continue
+      }
+      styles, state = w.buffer.lineStyles(pos, state)
+      k = 0
+    }
+    if pos == r.first {
+      selected = true
     }
     if pos == w.cur {
       w.curi, w.curj = i, j
-      ras.Cursor(i, j)
-      if w.mark != nil {
-        markDown = !markDown
-      }
+      ras.Cursor(w.offi + i, offj + j)
+      curLine = line
+    }
+    width := raster.Width(pos.c)
+    if pos.c == '\t' {
+      width = tab - j % tab
+    }
+    style := raster.NORMAL
+    if k < len(styles) {
+      style = styles[k]
+    }
+    k++
+    if selected && (r.Kind != BLOCKWISE || j <= right && j + width - 1 >= left) {
+      style = raster.HIGHLIGHT
     }
     if EOL(pos.c) {
       i++
       j = 0
     } else if pos.c == '\t' {
-      j++
-      for j % 8 != 0 {
+      for n := 0; n < width && j < cols; n++ {
+        if style != raster.NORMAL {
+          ras.Put(w.offi + i, offj + j, ' ', style)
+        }
         j++
       }
-    } else if j < w.cols {
-      style := raster.NORMAL
-      if markDown {
-        style = raster.HIGHLIGHT
-      }
-      ras.Put(i, j, pos.c, style)
-      j++
+    } else if j + width <= cols {
+      ras.Put(w.offi + i, offj + j, pos.c, style)
+      j += width
+    } else {
+      j = cols
+    }
+    if pos == r.last {
+      selected = false
     }
   }
+  if signCols + numberCols > 0 {
+    if curLine == 0 && (w.Gutter.Numbers == RELATIVE || w.Gutter.Numbers == HYBRID) {
+      curLine = w.cursorLine(w.topLine())
+    }
+    w.renderGutter(ras, lines, starts, curLine, signCols, numberCols)
+  }
 }
 
 func (w *Window) Right() {
-  if w.cur != nil && w.cur.next != nil {
+  if w.cur == nil {
+    return
+  }
+  if w.cur.next != nil {
     w.cur = w.cur.next 
   }
 }
 
+// Moves right as far as the end of the line, and no further.
+func (w *Window) RightOnLine() {
+  if w.cur != nil && !EOL(w.cur.c) {
+    w.cur = w.cur.next
+  }
+}
+
 func (w *Window) Left() {
-  if w.cur != nil && w.cur.prev != nil {
+  if w.cur == nil {
+    w.cur = w.buffer.tail
+  } else if w.cur.prev != nil {
     w.cur = w.cur.prev
   }
 }
 
+// Moves up a line. A closed fold counts as one line, its first.
 func (w *Window) Up() {
   col := w.Home()
+  if f := w.closedAt(w.cur); f != nil {
+    w.cur = f.first
+  }
   w.Left()
   w.Home()
-  for i := 0; i < col; i++ {
+  if f := w.closedAt(w.cur); f != nil {
+    w.cur = f.first
+  }
+  for i := 0; i < col && w.cur != nil && !EOL(w.cur.c); i++ {
     w.Right()
   }
   if w.curi == 0 {
@@ -313,11 +669,15 @@ func (w *Window) Up() {
   }
 }
 
+// Moves down a line. A closed fold counts as one line, its last.
 func (w *Window) Down() {
   col := w.Home()
+  if f := w.closedAt(w.cur); f != nil {
+    w.cur = f.last
+  }
   w.End()
   w.Right()
-  for i := 0; i < col; i++ {
+  for i := 0; i < col && w.cur != nil && !EOL(w.cur.c); i++ {
     w.Right()
   }
   if w.curi == w.rows - 1 {
@@ -383,12 +743,30 @@ func (p *node) delete() *node {
 }
 
 
+// Scrolls down a line, or past a closed fold.
 func (w *Window) ScrollDown() {
+  if w.top == nil {
+    return
+  }
+  from := w.top
+  if f := w.closedAt(w.top); f != nil {
+    w.top = f.last
+  }
   w.top, _ = w.top.seek(EOL)
+  w.scrolled(from, true)
 }
 
+// Scrolls up a line, or to the start of a closed fold.
 func (w *Window) ScrollUp() {
+  if w.top == nil {
+    return
+  }
+  from := w.top
   w.top, _ = w.top.seekback(EOL) 
+  if f := w.closedAt(w.top); f != nil {
+    w.top = f.first
+  }
+  w.scrolled(from, false)
 }
 
 func (w *Window) PageDown() {
@@ -404,13 +782,16 @@ func (w *Window) PageUp() {
 }
 
 func (w *Window) Home() (n int) {
+  if w.cur == nil || w.cur.prev == nil || EOL(w.cur.prev.c) {
+    return
+  }
   w.cur, n = w.cur.seekback(EOL) 
   return
 }
 
 func (w *Window) End() (n int) {
   i := 0
-  for w.cur.next != nil && !EOL(w.cur.c) {
+  for w.cur != nil && w.cur.next != nil && !EOL(w.cur.c) {
     i++
     w.cur = w.cur.next
   }
@@ -419,6 +800,19 @@ func (w *Window) End() (n int) {
 
 func (w *Window) Mark() {
   w.mark = w.cur
+  w.markKind = INCLUSIVE
+}
+
+// Starts selecting whole lines.
+func (w *Window) MarkLines() {
+  w.Mark()
+  w.markKind = LINEWISE
+}
+
+// Starts selecting a rectangle, from the cursor to wherever it goes.
+func (w *Window) MarkBlock() {
+  w.Mark()
+  w.markKind = BLOCKWISE
 }
 
 func (w *Window) ClearMark() {
@@ -433,25 +827,95 @@ func (w *Window) MarkedText() string {
   return builder.String()
 }
 
+// Returns the selection, or the rune at the cursor's position, leaving the
+// cursor at its start.
 func (w *Window) Yank() string {
   var builder strings.Builder
-  w.forMarked(func (p *node) {
-    builder.WriteRune(p.c)
-    p.delete()
-  })
-  builder.WriteRune(w.cur.c)
-  w.cur = w.cur.delete()
+  first, last := w.cur, w.cur
+  if w.mark != nil {
+    first, last = w.marked()
+  }
+  for pos := first; pos != nil; pos = pos.next {
+    builder.WriteRune(pos.c)
+    if pos == last {
+      break
+    }
+  }
+  if first != nil {
+    w.cur = first
+  }
+  w.mark = nil
   return builder.String()
 }
 
-func (w *Window) Find(pat *regexp.Regexp) {
-  //todo
+// The selection, or if there isn't one, the run of non-space text under the
+// cursor. Empty if the cursor is on space.
+func (w *Window) Target() string {
+  if w.mark != nil {
+    return w.MarkedText()
+  }
+  if w.cur == nil || unicode.IsSpace(w.cur.c) {
+    return ""
+  }
+  first, last := w.cur, w.cur
+  for first.prev != nil && !unicode.IsSpace(first.prev.c) {
+    first = first.prev
+  }
+  for last != nil && !unicode.IsSpace(last.c) {
+    last = last.next
+  }
+  var builder strings.Builder
+  for pos := first; pos != last; pos = pos.next {
+    builder.WriteRune(pos.c)
+  }
+  return builder.String()
+}
+
+// The text of the cursor's line, without its newline.
+func (w *Window) Line() string {
+  if w.cur == nil {
+    return ""
+  }
+  first := w.cur
+  for first.prev != nil && !EOL(first.prev.c) {
+    first = first.prev
+  }
+  var builder strings.Builder
+  for pos := first; pos != nil && !EOL(pos.c); pos = pos.next {
+    builder.WriteRune(pos.c)
+  }
+  return builder.String()
 }
 
-func (w *Window) FindReverse(pat *regexp.Regexp) {
-  //todo
+// The cursor's line and column, counting from 1.
+func (w *Window) Position() (line, col int) {
+  line, col = 1, 1
+  for pos := w.buffer.head; pos != nil && pos != w.cur; pos = pos.next {
+    col++
+    if EOL(pos.c) {
+      line++
+      col = 1
+    }
+  }
+  return
 }
 
-func (w *Window) Plumb() {
+// Moves the cursor to the given line and column, counting from 1, and
+// scrolls it into view.
+func (w *Window) GoTo(line, col int) {
+  pos := w.buffer.head
+  for i := 1; i < line && pos != nil; i++ {
+    for pos != nil && !EOL(pos.c) {
+      pos = pos.next
+    }
+    if pos != nil && pos.next != nil {
+      pos = pos.next
+    }
+  }
+  for j := 1; j < col && pos != nil && pos.next != nil && !EOL(pos.c); j++ {
+    pos = pos.next
+  }
+  w.cur = pos
+  w.reveal()
 }
 
@@ -0,0 +1,124 @@
+package buffer
+
+import (
+  "testing"
+)
+
+func TestOverwrite(t *testing.T) {
+  for _, test := range []struct {
+    text string
+    col int
+    typed string
+    want string
+  }{
+    {"abc\n", 1, "XY", "XYc\n"},
+    {"ab\n", 2, "XY", "aXY\n"},
+    // the last rune, with no newline after it
+    {"ab", 2, "X", "aX"},
+    {"ab", 2, "XY", "aXY"},
+    {"ab", 1, "XYZ", "XYZ"},
+    // backspace puts back what was overwritten
+    {"ab", 2, "X\x7F", "ab"},
+    {"ab", 1, "XYZ\x7F\x7F", "Xb"},
+  } {
+    b := &Buffer{}
+    b.AppendString(test.text)
+    w := b.Window("test", 10, 80)
+    w.GoTo(1, test.col)
+    for _, c := range test.typed {
+      w.Overwrite(c)
+    }
+    if got := b.String(); got != test.want {
+      t.Errorf("typing %q over %q at column %d gave %q, want %q", test.typed, test.text, test.col, got, test.want)
+    }
+  }
+}
+
+func TestDeleteRightOnLine(t *testing.T) {
+  for _, test := range []struct {
+    text string
+    col, count int
+    want, deleted string
+  }{
+    {"abcd\nef\n", 2, 2, "ad\nef\n", "bc"},
+    // only to the end of the line
+    {"abcd\nef\n", 2, 5, "a\nef\n", "bcd"},
+    {"abcd", 3, 5, "ab", "cd"},
+  } {
+    b := &Buffer{}
+    b.AppendString(test.text)
+    w := b.Window("test", 10, 80)
+    w.GoTo(1, test.col)
+    deleted := w.Apply('d', w.Motion(w.RightOnLine, test.count, EXCLUSIVE))
+    if got := b.String(); got != test.want || deleted != test.deleted {
+      t.Errorf("%dx at column %d of %q left %q, deleting %q, want %q, deleting %q",
+        test.count, test.col, test.text, got, deleted, test.want, test.deleted)
+    }
+  }
+}
+
+func TestDeleteWords(t *testing.T) {
+  for _, test := range []struct {
+    text string
+    col, count int
+    want, deleted string
+  }{
+    {"foo bar baz\n", 1, 1, "bar baz\n", "foo "},
+    {"foo bar baz\n", 1, 2, "baz\n", "foo bar "},
+    // the last word of a line stops at its end
+    {"foo bar\n    baz\n", 5, 1, "foo \n    baz\n", "bar"},
+    {"foo bar\n    baz\n", 1, 2, "\n    baz\n", "foo bar"},
+    {"foo bar\n\nbaz\n", 5, 1, "foo \n\nbaz\n", "bar"},
+    {"foo bar  \nbaz\n", 5, 1, "foo \nbaz\n", "bar  "},
+    // and the last word of the buffer goes whole
+    {"foo bar", 5, 1, "foo ", "bar"},
+    {"foo bar", 5, 3, "foo ", "bar"},
+    {"foo", 1, 1, "", "foo"},
+  } {
+    b := &Buffer{}
+    b.AppendString(test.text)
+    w := b.Window("test", 10, 80)
+    w.GoTo(1, test.col)
+    deleted := w.Apply('d', w.Words(test.count, false))
+    if got := b.String(); got != test.want || deleted != test.deleted {
+      t.Errorf("%ddw at column %d of %q left %q, deleting %q, want %q, deleting %q",
+        test.count, test.col, test.text, got, deleted, test.want, test.deleted)
+    }
+  }
+}
+
+func TestBefore(t *testing.T) {
+  b := &Buffer{}
+  b.AppendString("abcd")
+  var nodes []*node
+  for pos := b.head; pos != nil; pos = pos.next {
+    nodes = append(nodes, pos)
+  }
+  nodes = append(nodes, nil)
+  for i, p := range nodes {
+    for j, q := range nodes {
+      if got := before(p, q); got != (i < j) {
+        t.Errorf("before(%d, %d) gave %v", i, j, got)
+      }
+    }
+  }
+}
+
+func TestCursorLine(t *testing.T) {
+  b := &Buffer{}
+  b.AppendString("a\nbc\n\nd\n")
+  w := b.Window("test", 10, 80)
+  var nodes []*node
+  for pos := b.head; pos != nil; pos = pos.next {
+    nodes = append(nodes, pos)
+  }
+  nodes = append(nodes, nil)
+  for i, top := range nodes[:len(nodes) - 1] {
+    for j, cur := range nodes {
+      w.top, w.cur = top, cur
+      if got, want := w.cursorLine(b.lineOf(top)), b.lineOf(cur); got != want {
+        t.Errorf("cursor at %d with rune %d at the top gave line %d, want %d", j, i, got, want)
+      }
+    }
+  }
+}
@@ -0,0 +1,340 @@
+package buffer
+
+import (
+  "../raster"
+  "../syntax"
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// Lines that can be hidden behind one line summing them up. first and last
+// are the starts of the first and last lines, though edits can leave them
+// mid-line, so they are moved back to the start of their lines before use.
+type fold struct {
+  first, last *node
+  closed bool
+  // how many lines it had when the folds were last indexed
+  lines int
+}
+
+// Where the closed folds are, worked out again when the folds change, or
+// lines of the buffer start or end somewhere else.
+type foldIndex struct {
+  breaks int
+  // the outermost closed fold starting at each line
+  starts map[*node]*fold
+  // the outermost closed fold hiding each of the other lines
+  hidden map[*node]*fold
+}
+
+// The start of p's line.
+func lineStart(p *node) *node {
+  for p != nil && p.prev != nil && !EOL(p.prev.c) {
+    p = p.prev
+  }
+  return p
+}
+
+// The start of the line after p's, or nil.
+func nextLine(p *node) *node {
+  for p != nil && !EOL(p.c) {
+    p = p.next
+  }
+  if p != nil {
+    p = p.next
+  }
+  return p
+}
+
+// Calls each with the start of each of f's lines. Returns false if f has
+// fewer than two lines left, or its last line is no longer after its first.
+func (f *fold) walk(each func(start *node)) bool {
+  f.first, f.last = lineStart(f.first), lineStart(f.last)
+  if f.first == nil || f.first == f.last {
+    return false
+  }
+  for p := f.first; p != nil; p = nextLine(p) {
+    each(p)
+    if p == f.last {
+      return true
+    }
+  }
+  return false
+}
+
+// Adds a closed fold over the lines first and last are on, and those between.
+func (w *Window) addFold(first, last *node) {
+  f := &fold{first: lineStart(first), last: lineStart(last), closed: true}
+  if f.first == f.last {
+    return
+  }
+  w.buffer.anchor(&f.first)
+  w.buffer.anchor(&f.last)
+  w.folds = append(w.folds, f)
+  w.folded = nil
+}
+
+func (w *Window) removeFold(f *fold) {
+  w.buffer.unanchor(&f.first)
+  w.buffer.unanchor(&f.last)
+  for i, v := range w.folds {
+    if v == f {
+      w.folds = append(w.folds[:i], w.folds[i + 1:]...)
+      break
+    }
+  }
+  w.folded = nil
+}
+
+// Folds the lines of r, leaving the fold closed and the cursor on it.
+func (w *Window) Fold(r Range) {
+  if r.Empty() {
+    return
+  }
+  r = w.buffer.lines(r.first, r.last)
+  w.addFold(r.first, r.last)
+  w.cur, w.mark = r.first, nil
+}
+
+// Forgets all the folds.
+func (w *Window) ClearFolds() {
+  for len(w.folds) > 0 {
+    w.removeFold(w.folds[0])
+  }
+}
+
+// How far in the line starting at p is indented, and whether it is blank.
+func (b *Buffer) indentOf(p *node) (col int, empty bool) {
+  for ; p != nil && blank(p.c); p = p.next {
+    col += b.width(p.c, col)
+  }
+  return col, p == nil || EOL(p.c)
+}
+
+// Replaces the folds with one for each line followed by lines indented more
+// than it, covering them up to the last before one that isn't. Blank lines
+// go along with the lines around them. The folds start closed.
+func (w *Window) FoldIndent() {
+  w.ClearFolds()
+  type open struct {
+    start *node
+    indent int
+  }
+  var stack []open
+  // the start of the last line that wasn't blank
+  var last *node
+  end := func(indent int) {
+    for len(stack) > 0 && stack[len(stack) - 1].indent >= indent {
+      top := stack[len(stack) - 1]
+      stack = stack[:len(stack) - 1]
+      if top.start != last {
+        w.addFold(top.start, last)
+      }
+    }
+  }
+  for p := w.buffer.head; p != nil; p = nextLine(p) {
+    indent, empty := w.buffer.indentOf(p)
+    if empty {
+      continue
+    }
+    end(indent)
+    stack = append(stack, open{p, indent})
+    last = p
+  }
+  end(0)
+}
+
+// Replaces the folds with one from each bracket to its match, wherever that
+// is on a later line. Brackets the syntax has as strings or comments don't
+// count. The folds start closed.
+func (w *Window) FoldBrackets() {
+  w.ClearFolds()
+  type open struct {
+    c rune
+    start *node
+  }
+  var stack []open
+  var styles []raster.Style
+  var state syntax.State
+  for start := w.buffer.head; start != nil; start = nextLine(start) {
+    styles, state = w.buffer.lineStyles(start, state)
+    k := 0
+    for p := start; p != nil && !EOL(p.c); p, k = p.next, k + 1 {
+      if k < len(styles) && (styles[k] == raster.STRING || styles[k] == raster.COMMENT) {
+        continue
+      }
+      if strings.ContainsRune("([{", p.c) {
+        stack = append(stack, open{p.c, start})
+        continue
+      }
+      i := strings.IndexRune(")]}", p.c)
+      if i < 0 {
+        continue
+      }
+      // openers left unmatched inside this pair are dropped with it
+      for n := len(stack) - 1; n >= 0; n-- {
+        if stack[n].c == rune("([{"[i]) {
+          if stack[n].start != start {
+            w.addFold(stack[n].start, start)
+          }
+          stack = stack[:n]
+          break
+        }
+      }
+    }
+  }
+}
+
+// Finds the closed folds, dropping any that edits have emptied.
+func (w *Window) foldIndex() *foldIndex {
+  if w.folded != nil && w.folded.breaks == w.buffer.breaks {
+    return w.folded
+  }
+  x := &foldIndex{breaks: w.buffer.breaks, starts: map[*node]*fold{}, hidden: map[*node]*fold{}}
+  var closed []*fold
+  for _, f := range append([]*fold(nil), w.folds...) {
+    if !f.closed {
+      continue
+    }
+    f.lines = 0
+    if !f.walk(func(*node) { f.lines++ }) {
+      w.removeFold(f)
+      continue
+    }
+    closed = append(closed, f)
+  }
+  // outer folds first, so that the folds inside them are hidden with the rest
+  sort.SliceStable(closed, func(a, b int) bool {
+    return closed[a].lines > closed[b].lines
+  })
+  for _, f := range closed {
+    if x.starts[f.first] != nil || x.hidden[f.first] != nil {
+      continue
+    }
+    x.starts[f.first] = f
+    f.walk(func(s *node) {
+      if s != f.first {
+        x.hidden[s] = f
+      }
+    })
+  }
+  w.folded = x
+  return x
+}
+
+// The outermost closed fold p's line is in, if any.
+func (w *Window) closedAt(p *node) *fold {
+  if len(w.folds) == 0 || p == nil {
+    return nil
+  }
+  x := w.foldIndex()
+  start := lineStart(p)
+  if f := x.starts[start]; f != nil {
+    return f
+  }
+  return x.hidden[start]
+}
+
+// Whether the line starting at p is hidden in a closed fold.
+func (w *Window) hidden(p *node) bool {
+  return len(w.folds) > 0 && p != nil && w.foldIndex().hidden[p] != nil
+}
+
+// The innermost open fold the cursor's line is in, if any.
+func (w *Window) openAt() *fold {
+  var inner *fold
+  least := 0
+  start := lineStart(w.cur)
+  for _, f := range w.folds {
+    if f.closed {
+      continue
+    }
+    found, lines := false, 0
+    ok := f.walk(func(s *node) {
+      lines++
+      if s == start {
+        found = true
+      }
+    })
+    if ok && found && (inner == nil || lines < least) {
+      inner, least = f, lines
+    }
+  }
+  return inner
+}
+
+// Opens the closed fold the cursor is in.
+func (w *Window) OpenFold() bool {
+  f := w.closedAt(w.cur)
+  if f == nil {
+    return false
+  }
+  f.closed = false
+  w.folded = nil
+  return true
+}
+
+// Closes the innermost open fold around the cursor, leaving the cursor on
+// its first line.
+func (w *Window) CloseFold() bool {
+  f := w.openAt()
+  if f == nil {
+    return false
+  }
+  f.closed = true
+  w.folded = nil
+  w.cur = f.first
+  w.reveal()
+  return true
+}
+
+func (w *Window) ToggleFold() bool {
+  if w.closedAt(w.cur) != nil {
+    return w.OpenFold()
+  }
+  return w.CloseFold()
+}
+
+// Opens or closes all of the folds.
+func (w *Window) OpenAll(open bool) {
+  for _, f := range w.folds {
+    f.closed = !open
+  }
+  w.folded = nil
+  if f := w.closedAt(w.cur); f != nil {
+    w.cur = f.first
+  }
+}
+
+// Deletes the closed fold the cursor is in, or if there isn't one, the
+// innermost open one around it.
+func (w *Window) DeleteFold() bool {
+  f := w.closedAt(w.cur)
+  if f == nil {
+    f = w.openAt()
+  }
+  if f == nil {
+    return false
+  }
+  w.removeFold(f)
+  return true
+}
+
+// Draws the line a closed fold shows as on row i: how many lines it hides,
+// and the first of them.
+func (w *Window) summarize(ras *raster.Raster, f *fold, i, offj, cols int) {
+  text, _ := lineFrom(f.first)
+  s := fmt.Sprintf("+--%3d lines: %s ", f.lines, strings.TrimSpace(strings.ReplaceAll(text, "\t", " ")))
+  j := 0
+  for _, c := range s {
+    if j + raster.Width(c) > cols {
+      break
+    }
+    ras.Put(w.offi + i, offj + j, c, raster.FOLDED)
+    j += raster.Width(c)
+  }
+  for ; j < cols; j++ {
+    ras.Put(w.offi + i, offj + j, '-', raster.FOLDED)
+  }
+}
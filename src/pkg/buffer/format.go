@@ -0,0 +1,107 @@
+package buffer
+
+import (
+  "strings"
+  "unicode"
+)
+
+// Inserts one level of indentation before p, returning where it starts.
+func (b *Buffer) insertIndent(p *node) *node {
+  if !b.Config.ExpandTab {
+    return b.insertBefore(p, '\t')
+  }
+  first := b.insertBefore(p, ' ')
+  for k := 1; k < b.tabWidth(); k++ {
+    b.insertBefore(p, ' ')
+  }
+  return first
+}
+
+// Breaks the line at the cursor, starting the new line with the old one's
+// indentation if Config.AutoIndent is set.
+func (w *Window) newline() {
+  var indent []rune
+  if w.buffer.Config.AutoIndent {
+    start := w.cur
+    if start == nil {
+      start = w.buffer.tail
+    } else {
+      start = start.prev
+    }
+    for start != nil && !EOL(start.c) && start.prev != nil && !EOL(start.prev.c) {
+      start = start.prev
+    }
+    for p := start; p != nil && p != w.cur && blank(p.c); p = p.next {
+      indent = append(indent, p.c)
+    }
+  }
+  w.buffer.insertBefore(w.cur, '\n')
+  for _, c := range indent {
+    w.buffer.insertBefore(w.cur, c)
+  }
+}
+
+// How many columns s takes up, starting at column 0.
+func (b *Buffer) stringWidth(s string) (col int) {
+  for _, c := range s {
+    col += b.width(c, col)
+  }
+  return
+}
+
+// Refills the paragraphs in r's lines so that no line is longer than
+// Config.TextWidth, unless a word is. Each paragraph keeps its first line's
+// indentation, and the empty lines between paragraphs are left be.
+func (w *Window) Reflow(r Range) {
+  if r.Empty() {
+    return
+  }
+  r = w.buffer.lines(r.first, r.last)
+  limit := w.buffer.Config.TextWidth
+  if limit <= 0 {
+    limit = 79
+  }
+  q0 := w.buffer.offsetOf(r.first)
+  q1 := w.buffer.offsetOf(r.last) + 1
+  text, _ := span(r.first, r.last.next)
+  end := ""
+  if strings.HasSuffix(text, "\n") {
+    text, end = text[:len(text) - 1], "\n"
+  }
+  var out []string
+  var paragraph []string
+  fill := func() {
+    if len(paragraph) == 0 {
+      return
+    }
+    first := paragraph[0]
+    indent := first[:len(first) - len(strings.TrimLeftFunc(first, unicode.IsSpace))]
+    line := ""
+    for _, word := range strings.Fields(strings.Join(paragraph, " ")) {
+      if line != "" && w.buffer.stringWidth(line + " " + word) > limit {
+        out = append(out, line)
+        line = ""
+      }
+      if line == "" {
+        line = indent + word
+      } else {
+        line += " " + word
+      }
+    }
+    out = append(out, line)
+    paragraph = nil
+  }
+  for _, line := range strings.Split(text, "\n") {
+    if strings.TrimSpace(line) == "" {
+      fill()
+      out = append(out, line)
+    } else {
+      paragraph = append(paragraph, line)
+    }
+  }
+  fill()
+  w.mark = nil
+  w.buffer.Replace(q0, q1, strings.Join(out, "\n") + end)
+  w.cur = w.buffer.nodeAt(q0)
+  w.reveal()
+}
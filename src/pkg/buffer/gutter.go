@@ -0,0 +1,150 @@
+package buffer
+
+import (
+  "../raster"
+  "fmt"
+)
+
+// What a window draws to the left of its text: a column for signs, and a
+// space after it, then line numbers, and a space after them.
+type Gutter struct {
+  // NONE, ABSOLUTE, RELATIVE or HYBRID
+  Numbers int
+  // whether there is a column for signs: if 0, only while there are some,
+  // if positive, always, and if negative, never
+  Signs int
+}
+
+// How lines are numbered.
+const (
+  NONE = iota
+  ABSOLUTE
+  // by how far they are from the cursor's line
+  RELATIVE
+  // relative, but for the cursor's own line
+  HYBRID
+)
+
+// The line at the top of a window, as it was last counted.
+type numbered struct {
+  top *node
+  breaks, line int
+}
+
+// The line the top of the window is on, counting from 1. It is only counted
+// from the start of the buffer again when lines have been added or removed,
+// or the window has jumped rather than scrolled.
+func (w *Window) topLine() int {
+  if w.numbered.top != w.top || w.numbered.breaks != w.buffer.breaks {
+    w.numbered = numbered{w.top, w.buffer.breaks, w.buffer.lineOf(w.top)}
+  }
+  return w.numbered.line
+}
+
+// The line the cursor is on, for when it isn't drawn, given top, the line at
+// the top of the window. Counts the lines between the two, looking both ways
+// from the top at once.
+func (w *Window) cursorLine(top int) int {
+  if w.cur == w.top {
+    return top
+  }
+  down, up := 0, 0
+  for next, prev := w.top, w.top; next != nil || prev != nil; {
+    if next != nil {
+      if EOL(next.c) {
+        down++
+      }
+      if next = next.next; next == w.cur {
+        return top + down
+      }
+    }
+    if prev != nil {
+      if prev = prev.prev; prev != nil {
+        if EOL(prev.c) {
+          up++
+        }
+        if prev == w.cur {
+          return top - up
+        }
+      }
+    }
+  }
+  return top
+}
+
+// Keeps the top line's number up to date after scrolling from from, by
+// counting the lines scrolled past.
+func (w *Window) scrolled(from *node, down bool) {
+  if w.numbered.top != from || w.numbered.breaks != w.buffer.breaks {
+    return
+  }
+  first, last := from, w.top
+  if !down {
+    first, last = w.top, from
+  }
+  lines := 0
+  for p := first; p != nil && p != last; p = p.next {
+    if EOL(p.c) {
+      lines++
+    }
+  }
+  if !down {
+    lines = -lines
+  }
+  w.numbered.top, w.numbered.line = w.top, w.numbered.line + lines
+}
+
+// How many columns the gutter takes up, for signs and for line numbers, with
+// top the line at the top of the window.
+func (w *Window) gutterWidth(top int) (signs, numbers int) {
+  if w.Gutter.Signs > 0 || w.Gutter.Signs == 0 && len(w.buffer.signs) > 0 {
+    signs = 2
+  }
+  if w.Gutter.Numbers != NONE {
+    numbers = len(fmt.Sprint(top + w.rows)) + 1
+    if numbers < 4 {
+      numbers = 4
+    }
+  }
+  if signs + numbers >= w.cols {
+    return 0, 0
+  }
+  return
+}
+
+// Draws the gutter beside each row, given the line drawn on it, if any, where
+// that line starts, and the cursor's line.
+func (w *Window) renderGutter(ras *raster.Raster, lines []int, starts []*node, cur, signCols, numberCols int) {
+  if len(lines) == 0 {
+    return
+  }
+  var signs map[int]Sign
+  if signCols > 0 {
+    on := make(map[*node]int)
+    for i, s := range starts {
+      if s != nil {
+        on[s] = lines[i]
+      }
+    }
+    signs = w.buffer.signsFrom(lines[0], on)
+  }
+  for i, line := range lines {
+    if line == 0 {
+      continue
+    }
+    if s, ok := signs[line]; ok {
+      ras.Put(w.offi + i, w.offj, s.Mark, s.Style)
+    }
+    if numberCols == 0 {
+      continue
+    }
+    n := line
+    if w.Gutter.Numbers == RELATIVE || w.Gutter.Numbers == HYBRID && line != cur {
+      n = line - cur
+      if n < 0 {
+        n = -n
+      }
+    }
+    ras.PutString(w.offi + i, w.offj + signCols, 0, fmt.Sprintf("%*d", numberCols - 1, n), raster.GUTTER)
+  }
+}
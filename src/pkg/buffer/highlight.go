@@ -0,0 +1,162 @@
+package buffer
+
+import (
+  "../raster"
+  "../syntax"
+  "strings"
+)
+
+// How many lines apart states are remembered, and how far back to look for
+// one before guessing that a line starts outside of any region.
+const (
+  checkEvery = 32
+  lookBack = 2000
+)
+
+// The state a line starts in, remembered so highlighting can start there.
+type checkpoint struct {
+  start *node
+  state syntax.State
+}
+
+// Highlights a buffer by a grammar, remembering the state of every
+// checkEvery'th line from the start, up to the first edit since they were
+// worked out.
+type highlighter struct {
+  grammar *syntax.Grammar
+  checks []checkpoint
+  index map[*node]int
+}
+
+// Highlights the buffer by g from now on, or stops if g is nil.
+func (b *Buffer) SetSyntax(g *syntax.Grammar) {
+  b.highlight = nil
+  if g != nil {
+    b.highlight = &highlighter{grammar: g, index: map[*node]int{}}
+  }
+}
+
+func (b *Buffer) Syntax() *syntax.Grammar {
+  if b.highlight == nil {
+    return nil
+  }
+  return b.highlight.grammar
+}
+
+// Forgets the states of lines after p's, which has just had p linked in, or
+// is about to have it unlinked.
+//
+// Lines up to the last remembered state are never more than checkEvery apart,
+// as an edit between two forgets the second, so if none is found that far
+// back from p, p is past them all.
+func (b *Buffer) touched(p *node) {
+  h := b.highlight
+  if h == nil || len(h.checks) == 0 {
+    return
+  }
+  lines := 0
+  for q := p; q != nil && lines <= checkEvery; q = q.prev {
+    if k, ok := h.index[q]; ok {
+      if q != p {
+        k++
+      }
+      h.truncate(k)
+      return
+    }
+    if q.prev == nil || EOL(q.prev.c) {
+      lines++
+    }
+  }
+}
+
+func (h *highlighter) truncate(k int) {
+  for _, c := range h.checks[k:] {
+    delete(h.index, c.start)
+  }
+  h.checks = h.checks[:k]
+}
+
+// The start of the line before the one starting at p, or nil.
+func lineBefore(p *node) *node {
+  if p == nil || p.prev == nil {
+    return nil
+  }
+  p = p.prev
+  for p.prev != nil && !EOL(p.prev.c) {
+    p = p.prev
+  }
+  return p
+}
+
+// The text of the line starting at p, without its end, and the start of the
+// next line.
+func lineFrom(p *node) (string, *node) {
+  var builder strings.Builder
+  for ; p != nil && !EOL(p.c); p = p.next {
+    builder.WriteRune(p.c)
+  }
+  if p != nil {
+    p = p.next
+  }
+  return builder.String(), p
+}
+
+// The state the line starting at start starts in, worked out from the
+// nearest remembered state before it. Remembers more states on the way when
+// working past the last one.
+func (b *Buffer) stateAt(start *node) syntax.State {
+  h := b.highlight
+  from, k := start, -1
+  for lines := 0; from != nil; lines++ {
+    if n, ok := h.index[from]; ok {
+      k = n
+      break
+    }
+    if lines > lookBack {
+      // too far to go every time, so guess
+      return 0
+    }
+    if from.prev == nil {
+      break
+    }
+    from = lineBefore(from)
+  }
+  state := syntax.State(0)
+  if k >= 0 {
+    state = h.checks[k].state
+  } else {
+    // nothing remembered before start, so nothing after it can be kept in order
+    h.truncate(0)
+    from = b.head
+    if from != nil {
+      h.add(from, 0)
+    }
+    k = 0
+  }
+  frontier := k == len(h.checks) - 1
+  for lines := 1; from != nil && from != start; lines++ {
+    var line string
+    line, from = lineFrom(from)
+    _, state = h.grammar.Line(line, state)
+    if frontier && lines % checkEvery == 0 && from != nil {
+      h.add(from, state)
+    }
+  }
+  return state
+}
+
+func (h *highlighter) add(start *node, state syntax.State) {
+  h.index[start] = len(h.checks)
+  h.checks = append(h.checks, checkpoint{start, state})
+}
+
+// Styles the line starting at start, which starts in state, returning the
+// style of each rune up to its end and the state the next line starts in.
+// Without a grammar there are no styles.
+func (b *Buffer) lineStyles(start *node, state syntax.State) ([]raster.Style, syntax.State) {
+  if b.highlight == nil {
+    return nil, 0
+  }
+  line, _ := lineFrom(start)
+  return b.highlight.grammar.Line(line, state)
+}
@@ -0,0 +1,84 @@
+package buffer
+
+// A position in a Buffer that follows edits. When the rune a Marker is on is
+// deleted, the Marker moves to the rune after it, so it never dangles.
+type Marker struct {
+  pos *node
+  buffer *Buffer
+}
+
+// Tracks a position so that deleting the rune it's on moves it instead.
+func (b *Buffer) anchor(p **node) {
+  if b.anchors == nil {
+    b.anchors = make(map[**node]bool)
+  }
+  b.anchors[p] = true
+}
+
+func (b *Buffer) unanchor(p **node) {
+  delete(b.anchors, p)
+}
+
+// Returns a Marker at the start of the buffer.
+func (b *Buffer) NewMarker() *Marker {
+  m := &Marker{pos: b.head, buffer: b}
+  b.anchor(&m.pos)
+  return m
+}
+
+// Returns a Marker at the cursor.
+func (w *Window) NewMarker() *Marker {
+  m := w.buffer.NewMarker()
+  m.pos = w.cur
+  return m
+}
+
+// Stops tracking the Marker. It must not be used afterwards.
+func (m *Marker) Release() {
+  m.buffer.unanchor(&m.pos)
+}
+
+func (m *Marker) Buffer() *Buffer {
+  return m.buffer
+}
+
+// Moves the Marker to the cursor.
+func (m *Marker) Set(w *Window) {
+  if w.buffer == m.buffer {
+    m.pos = w.cur
+  }
+}
+
+// Moves the cursor to the Marker, scrolling it into view if need be.
+func (w *Window) Jump(m *Marker) {
+  if m.buffer != w.buffer {
+    return
+  }
+  w.cur = m.pos
+  w.reveal()
+}
+
+// Scrolls so that the cursor's line is on screen.
+func (w *Window) reveal() {
+  if w.cur == nil {
+    return
+  }
+  lines := 0
+  for pos := w.top; pos != nil && lines < w.rows; pos = pos.next {
+    if pos == w.cur {
+      return
+    }
+    // lines hidden in a closed fold don't take up rows of their own
+    if EOL(pos.c) && !w.hidden(pos.next) {
+      lines++
+    }
+  }
+  // not in view, so put it a third of the way down
+  w.top = w.cur
+  if w.top.prev != nil && !EOL(w.top.prev.c) {
+    w.top, _ = w.top.seekback(EOL)
+  }
+  for i := 0; i < w.rows / 3; i++ {
+    w.ScrollUp()
+  }
+}
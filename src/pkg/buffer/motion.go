@@ -0,0 +1,217 @@
+package buffer
+
+import (
+  "strings"
+  "unicode"
+)
+
+// What kind of word c belongs in: 0 for space, 1 for letters, digits and _,
+// and 2 for anything else. A WORD is any run of text without space.
+func class(c rune, WORD bool) int {
+  if unicode.IsSpace(c) {
+    return 0
+  } else if WORD || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c) {
+    return 1
+  }
+  return 2
+}
+
+// Whether p starts an empty line.
+func empty(p *node) bool {
+  return p != nil && EOL(p.c) && (p.prev == nil || EOL(p.prev.c))
+}
+
+// The start of the word, or WORD, after the one p is in, stopping at empty
+// lines, or nil if there isn't one.
+func nextWord(p *node, WORD bool) *node {
+  start := p
+  for k := class(p.c, WORD); p != nil && k != 0 && class(p.c, WORD) == k; {
+    p = p.next
+  }
+  for p != nil && class(p.c, WORD) == 0 && !(p != start && empty(p)) {
+    p = p.next
+  }
+  return p
+}
+
+// Moves to the start of the next word, or WORD, stopping at empty lines, or
+// to the last rune if there isn't one.
+func (w *Window) WordForward(WORD bool) {
+  if w.cur == nil {
+    return
+  }
+  if w.cur = nextWord(w.cur, WORD); w.cur == nil {
+    w.cur = w.buffer.tail
+  }
+  w.reveal()
+}
+
+// Moves to the start of this word, or the one before if already there.
+func (w *Window) WordBack(WORD bool) {
+  w.Left()
+  p := w.cur
+  if p == nil {
+    return
+  }
+  for p.prev != nil && class(p.c, WORD) == 0 && !empty(p) {
+    p = p.prev
+  }
+  for k := class(p.c, WORD); k != 0 && p.prev != nil && class(p.prev.c, WORD) == k; {
+    p = p.prev
+  }
+  w.cur = p
+  w.reveal()
+}
+
+// Moves to the end of this word, or the one after if already there.
+func (w *Window) WordEnd(WORD bool) {
+  p := w.cur
+  if p == nil || p.next == nil {
+    return
+  }
+  p = p.next
+  for p.next != nil && class(p.c, WORD) == 0 {
+    p = p.next
+  }
+  for k := class(p.c, WORD); k != 0 && p.next != nil && class(p.next.c, WORD) == k; {
+    p = p.next
+  }
+  w.cur = p
+  w.reveal()
+}
+
+// Moves to the next empty line, or the end of the buffer.
+func (w *Window) ParagraphNext() {
+  if w.cur == nil {
+    return
+  }
+  p := w.cur
+  // get off the empty lines we're on first
+  for p.next != nil && empty(p) {
+    p, _ = p.seek(EOL)
+  }
+  for p.next != nil && !empty(p) {
+    p, _ = p.seek(EOL)
+  }
+  if !empty(p) {
+    for p.next != nil {
+      p = p.next
+    }
+  }
+  w.cur = p
+  w.reveal()
+}
+
+// Moves to the previous empty line, or the start of the buffer.
+func (w *Window) ParagraphPrev() {
+  w.Home()
+  p := w.cur
+  if p == nil {
+    p = w.buffer.tail
+  }
+  if p == nil {
+    return
+  }
+  for p.prev != nil && empty(p) {
+    p, _ = p.seekback(EOL)
+  }
+  for p.prev != nil && !empty(p) {
+    p = p.prev
+    if p.prev != nil && !EOL(p.prev.c) {
+      p, _ = p.seekback(EOL)
+    }
+  }
+  w.cur = p
+  w.reveal()
+}
+
+const brackets = "(){}[]"
+
+// Moves to the bracket matching the one at the cursor, or the first one after
+// it on the line, skipping over nested pairs. Returns false if there's none.
+func (w *Window) MatchBracket() bool {
+  p := w.cur
+  for p != nil && !EOL(p.c) && !strings.ContainsRune(brackets, p.c) {
+    p = p.next
+  }
+  if p == nil || EOL(p.c) {
+    return false
+  }
+  k := strings.IndexRune(brackets, p.c)
+  open, close := rune(brackets[k &^ 1]), rune(brackets[k | 1])
+  depth := 0
+  for q := p; q != nil; {
+    if q.c == open {
+      depth++
+    } else if q.c == close {
+      depth--
+    }
+    if depth == 0 {
+      w.cur = q
+      w.reveal()
+      return true
+    }
+    if k % 2 == 0 {
+      q = q.next
+    } else {
+      q = q.prev
+    }
+  }
+  return false
+}
+
+func (w *Window) DocStart() {
+  w.cur = w.buffer.head
+  w.reveal()
+}
+
+// Moves to the start of the last line.
+func (w *Window) DocEnd() {
+  w.cur = w.buffer.tail
+  if w.cur != nil && EOL(w.cur.c) && w.cur.prev != nil {
+    w.cur = w.cur.prev
+  }
+  w.Home()
+  w.reveal()
+}
+
+// Moves to the first rune on the line that isn't a space.
+func (w *Window) FirstNonBlank() {
+  w.Home()
+  for w.cur != nil && w.cur.next != nil && !EOL(w.cur.c) && unicode.IsSpace(w.cur.c) {
+    w.cur = w.cur.next
+  }
+}
+
+// Moves to the next c on the line, or the previous one if not forward. With
+// till, stops just short of it. Returns false, without moving, if there isn't
+// one.
+func (w *Window) FindRune(c rune, forward, till bool) bool {
+  p := w.cur
+  if p == nil {
+    return false
+  }
+  step := func(p *node) *node {
+    if forward {
+      return p.next
+    }
+    return p.prev
+  }
+  // when repeating a t, don't stop short of the c we're next to again
+  if till && step(p) != nil && step(p).c == c {
+    p = step(p)
+  }
+  for p = step(p); p != nil && !EOL(p.c); p = step(p) {
+    if p.c != c {
+      continue
+    }
+    if till && forward {
+      p = p.prev
+    } else if till {
+      p = p.next
+    }
+    w.cur = p
+    return true
+  }
+  return false
+}
@@ -0,0 +1,271 @@
+package buffer
+
+import (
+  "regexp"
+  "strings"
+)
+
+// Text objects, the text around the cursor that an operator or selection can
+// take whole.
+
+func blank(c rune) bool {
+  return c == ' ' || c == '\t'
+}
+
+// Whether p is escaped by an odd number of backslashes before it.
+func escaped(p *node) bool {
+  n := 0
+  for q := p.prev; q != nil && q.c == '\\'; q = q.prev {
+    n++
+  }
+  return n % 2 == 1
+}
+
+// The text object named by c around the cursor: w or W for a word, " ' or `
+// for a quoted string, ( ) b, { } B, [ ], or < > for brackets, p for a
+// paragraph and t for an XML tag. Inner objects leave out the quotes,
+// brackets, tags or surrounding space. Returns false if there's no such
+// object around the cursor. If the object is there but empty, the range is
+// empty and the cursor is moved to where it would be.
+func (w *Window) Object(c rune, inner bool) (Range, bool) {
+  if w.cur == nil {
+    return Range{}, false
+  }
+  switch (c) {
+  case 'w', 'W':
+    return w.word(c == 'W', inner), true
+  case '"', '\'', '`':
+    return w.quoted(c, inner)
+  case '(', ')', 'b':
+    return w.bracketed('(', ')', inner)
+  case '{', '}', 'B':
+    return w.bracketed('{', '}', inner)
+  case '[', ']':
+    return w.bracketed('[', ']', inner)
+  case '<', '>':
+    return w.bracketed('<', '>', inner)
+  case 'p':
+    return w.paragraph(inner), true
+  case 't':
+    return w.tag(inner)
+  }
+  return Range{}, false
+}
+
+// The word, or run of space, at the cursor. Around a word takes the space
+// after it too, or if there isn't any, the space before it.
+func (w *Window) word(WORD, inner bool) Range {
+  same := func(a, b rune) bool {
+    if blank(a) || blank(b) {
+      return blank(a) && blank(b)
+    }
+    return !EOL(a) && !EOL(b) && class(a, WORD) == class(b, WORD)
+  }
+  first, last := w.cur, w.cur
+  for first.prev != nil && same(first.prev.c, first.c) {
+    first = first.prev
+  }
+  for last.next != nil && same(last.next.c, last.c) {
+    last = last.next
+  }
+  if inner || EOL(w.cur.c) {
+    return Range{first, last, INCLUSIVE}
+  }
+  if blank(w.cur.c) {
+    // around space is the space and the word after it
+    if p := last.next; p != nil && !EOL(p.c) {
+      for k := class(p.c, WORD); last.next != nil && !EOL(last.next.c) && class(last.next.c, WORD) == k; {
+        last = last.next
+      }
+    }
+    return Range{first, last, INCLUSIVE}
+  }
+  if last.next != nil && blank(last.next.c) {
+    for last.next != nil && blank(last.next.c) {
+      last = last.next
+    }
+  } else {
+    for first.prev != nil && blank(first.prev.c) {
+      first = first.prev
+    }
+  }
+  return Range{first, last, INCLUSIVE}
+}
+
+// Pairs up unescaped q quotes on the cursor's line, and takes the pair the
+// cursor is in, or failing that the first pair after it. Around a string
+// takes the space after it too.
+func (w *Window) quoted(q rune, inner bool) (Range, bool) {
+  start := w.cur
+  for start.prev != nil && !EOL(start.prev.c) {
+    start = start.prev
+  }
+  var quotes []*node
+  past, found := false, false
+  for p := start; p != nil && !EOL(p.c); p = p.next {
+    if p == w.cur {
+      past = true
+    }
+    if p.c != q || escaped(p) {
+      continue
+    }
+    quotes = append(quotes, p)
+    // the first pair to close at or after the cursor is either around it or
+    // the first after it
+    if past && len(quotes) % 2 == 0 {
+      found = true
+      break
+    }
+  }
+  if !found {
+    return Range{}, false
+  }
+  open, close := quotes[len(quotes) - 2], quotes[len(quotes) - 1]
+  if !inner {
+    for close.next != nil && blank(close.next.c) {
+      close = close.next
+    }
+    return Range{open, close, INCLUSIVE}, true
+  }
+  if open.next == close {
+    w.cur = close
+    return Range{}, true
+  }
+  return Range{open.next, close.prev, INCLUSIVE}, true
+}
+
+// The innermost open and close brackets around the cursor, skipping nested
+// and escaped ones. Inner brackets on lines of their own leave those lines be.
+func (w *Window) bracketed(open, close rune, inner bool) (Range, bool) {
+  depth := 0
+  var left *node
+  for p := w.cur; p != nil; p = p.prev {
+    if p.c == close && p != w.cur && !escaped(p) {
+      depth++
+    } else if p.c == open && !escaped(p) {
+      if depth == 0 {
+        left = p
+        break
+      }
+      depth--
+    }
+  }
+  if left == nil {
+    return Range{}, false
+  }
+  var right *node
+  depth = 0
+  for p := left.next; p != nil; p = p.next {
+    if p.c == open && !escaped(p) {
+      depth++
+    } else if p.c == close && !escaped(p) {
+      if depth == 0 {
+        right = p
+        break
+      }
+      depth--
+    }
+  }
+  if right == nil {
+    return Range{}, false
+  }
+  if !inner {
+    return Range{left, right, INCLUSIVE}, true
+  }
+  first, last := left.next, right.prev
+  if first != right && EOL(first.c) {
+    first = first.next
+  }
+  // leave the indentation before a close bracket on a line of its own
+  p := last
+  for p != left && blank(p.c) {
+    p = p.prev
+  }
+  if p != left && EOL(p.c) && p != first.prev {
+    last = p
+  }
+  if first == right || before(last, first) {
+    w.cur = right
+    return Range{}, true
+  }
+  return Range{first, last, INCLUSIVE}, true
+}
+
+// The lines around the cursor up to the empty lines either side, or if the
+// cursor's line is empty, the empty lines around it. Around a paragraph takes
+// the empty lines after it too.
+func (w *Window) paragraph(inner bool) Range {
+  r := w.buffer.lines(w.cur, w.cur)
+  blankLine := empty(r.first)
+  same := func(p *node) bool {
+    return p != nil && empty(p) == blankLine
+  }
+  for r.first.prev != nil {
+    p := w.buffer.lines(r.first.prev, r.first.prev).first
+    if !same(p) {
+      break
+    }
+    r.first = p
+  }
+  extend := func(want func(p *node) bool) {
+    for r.last.next != nil && want(r.last.next) {
+      r.last = w.buffer.lines(r.last.next, r.last.next).last
+    }
+  }
+  extend(same)
+  if !inner {
+    extend(func(p *node) bool {
+      return empty(p) != blankLine
+    })
+  }
+  return r
+}
+
+var tags = regexp.MustCompile(`<(/?)([A-Za-z][\w:.-]*)[^>]*?(/?)>`)
+
+// The innermost XML tag around the cursor, open and close tags and all, or
+// just what's between them if inner.
+func (w *Window) tag(inner bool) (Range, bool) {
+  text, nodes := span(w.buffer.head, nil)
+  cur := offset(nodes, w.cur)
+  type open struct {
+    name string
+    at []int
+  }
+  var stack []open
+  var best []int
+  for _, m := range tags.FindAllStringSubmatchIndex(text, -1) {
+    name := text[m[4]:m[5]]
+    if m[7] > m[6] {
+      // self-closing
+      continue
+    } else if m[3] == m[2] {
+      stack = append(stack, open{name, m})
+      continue
+    }
+    // close the nearest tag of the same name, dropping any left open inside it
+    k := len(stack) - 1
+    for k >= 0 && !strings.EqualFold(stack[k].name, name) {
+      k--
+    }
+    if k < 0 {
+      continue
+    }
+    o := stack[k].at
+    stack = stack[:k]
+    if o[0] <= cur && cur < m[1] && (best == nil || o[0] > best[0]) {
+      best = []int{o[0], o[1], m[0], m[1]}
+    }
+  }
+  if best == nil {
+    return Range{}, false
+  }
+  if !inner {
+    return Range{nodes[best[0]], nodes[best[3] - 1], INCLUSIVE}, true
+  }
+  if best[1] == best[2] {
+    w.cur = nodes[best[2]]
+    return Range{}, true
+  }
+  return Range{nodes[best[1]], nodes[best[2] - 1], INCLUSIVE}, true
+}
@@ -0,0 +1,40 @@
+package buffer
+
+import (
+  "testing"
+)
+
+func TestQuoted(t *testing.T) {
+  for _, test := range []struct {
+    line string
+    col int
+    want string
+    ok bool
+  }{
+    {`x := "ab" + "cd"`, 7, "ab", true},
+    // between the strings, and before them, the next one is taken
+    {`x := "ab" + "cd"`, 11, "cd", true},
+    {`x := "ab" + "cd"`, 1, "ab", true},
+    // on either quote
+    {`x := "ab" + "cd"`, 9, "ab", true},
+    {`x := "ab" + "cd"`, 13, "cd", true},
+    {`x := "a\"b"`, 9, `a\"b`, true},
+    // nothing after the cursor
+    {`x := "ab" + y`, 13, "", false},
+  } {
+    b := &Buffer{}
+    b.AppendString(test.line + "\n")
+    w := b.Window("test", 10, 80)
+    w.GoTo(1, test.col)
+    r, ok := w.quoted('"', true)
+    got := ""
+    if ok && !r.Empty() {
+      for p := r.first; p != r.last.next; p = p.next {
+        got += string(p.c)
+      }
+    }
+    if ok != test.ok || got != test.want {
+      t.Errorf("inside quotes from column %d of %s gave %q, %v, want %q, %v", test.col, test.line, got, ok, test.want, test.ok)
+    }
+  }
+}
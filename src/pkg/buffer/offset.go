@@ -0,0 +1,89 @@
+package buffer
+
+// Positions counted in runes from the start of the buffer, for callers that
+// can't hold on to nodes.
+
+func (b *Buffer) Len() (n int) {
+  for pos := b.head; pos != nil; pos = pos.next {
+    n++
+  }
+  return
+}
+
+// A node and its offset, as of a version of the buffer. Edits made one after
+// another through a buffer are usually near each other, so the node each was
+// at is a better place to start looking for the next than the head.
+type near struct {
+  version, q int
+  p *node
+}
+
+// The node q runes in, or nil at the end.
+func (b *Buffer) nodeAt(q int) *node {
+  pos, at := b.head, 0
+  if n := b.near; n.p != nil && n.version == b.version && (q >= n.q || n.q - q < q) {
+    pos, at = n.p, n.q
+  }
+  for ; at > q; at-- {
+    pos = pos.prev
+  }
+  for ; at < q && pos != nil; at++ {
+    pos = pos.next
+  }
+  if pos != nil {
+    b.near = near{b.version, q, pos}
+  }
+  return pos
+}
+
+func (b *Buffer) offsetOf(p *node) (q int) {
+  for pos := b.head; pos != nil && pos != p; pos = pos.next {
+    q++
+  }
+  return
+}
+
+// Replaces the runes from q0 up to q1 with s.
+func (b *Buffer) Replace(q0, q1 int, s string) {
+  p := b.nodeAt(q0)
+  for k := q0; k < q1 && p != nil; k++ {
+    next := p.next
+    b.remove(p)
+    p = next
+  }
+  n := 0
+  for _, c := range s {
+    b.insertBefore(p, c)
+    n++
+  }
+  if p != nil {
+    b.near = near{b.version, q0 + n, p}
+  }
+}
+
+// The selection, or an empty range at the cursor, as rune offsets.
+func (w *Window) Dot() (q0, q1 int) {
+  if w.mark == nil {
+    q0 = w.buffer.offsetOf(w.cur)
+    return q0, q0
+  }
+  r := w.Selection()
+  first, last := r.first, r.last
+  q0 = w.buffer.offsetOf(first)
+  q1 = w.buffer.offsetOf(last)
+  if last != nil {
+    q1++
+  }
+  return
+}
+
+// Selects the runes from q0 up to q1, or just moves the cursor if there are none.
+func (w *Window) SetDot(q0, q1 int) {
+  w.mark = nil
+  w.cur = w.buffer.nodeAt(q0)
+  if q1 > q0 {
+    w.mark, w.markKind = w.cur, INCLUSIVE
+    w.cur = w.buffer.nodeAt(q1 - 1)
+  }
+  w.reveal()
+}
@@ -0,0 +1,249 @@
+package buffer
+
+// A run of text from first through last, for an operator to act on. Its Kind
+// is INCLUSIVE, LINEWISE for whole lines, or BLOCKWISE for the rectangle
+// with first and last at its corners.
+type Range struct {
+  first, last *node
+  Kind int
+}
+
+func (r Range) Empty() bool {
+  return r.first == nil
+}
+
+// How much of the text a motion covers, for an operator.
+const (
+  // up to but not including where it stops, like h
+  EXCLUSIVE = iota
+  // up to and including where it stops, like $, though never the end of line
+  INCLUSIVE
+  // every line from the one it starts on to the one it stops on, like j
+  LINEWISE
+  // the columns it passes over on every line, for selections
+  BLOCKWISE
+)
+
+// Whether p comes before q, where nil is the end of the buffer. Looks both
+// ways from p at once, so that it takes as long as they are far apart rather
+// than as long as the rest of the buffer.
+func before(p, q *node) bool {
+  if p == nil || p == q {
+    return false
+  } else if q == nil {
+    return true
+  }
+  for next, prev := p.next, p.prev; next != nil || prev != nil; {
+    if next == q {
+      return true
+    } else if prev == q {
+      return false
+    }
+    if next != nil {
+      next = next.next
+    }
+    if prev != nil {
+      prev = prev.prev
+    }
+  }
+  return false
+}
+
+// The text the cursor passes over when move is called count times, or once
+// without a count. The cursor is left where it was.
+func (w *Window) Motion(move func(), count, kind int) Range {
+  from := w.cur
+  for i := 0; i < count || i == 0; i++ {
+    move()
+  }
+  to := w.cur
+  w.cur = from
+  if before(to, from) {
+    from, to = to, from
+  }
+  switch (kind) {
+  case EXCLUSIVE:
+    if from == to {
+      return Range{}
+    } else if to == nil {
+      return Range{from, w.buffer.tail, INCLUSIVE}
+    }
+    return Range{from, to.prev, INCLUSIVE}
+  case INCLUSIVE:
+    if to == nil {
+      to = w.buffer.tail
+    }
+    if from != nil && to != nil && EOL(to.c) {
+      if to == from {
+        return Range{}
+      }
+      to = to.prev
+    }
+    return Range{from, to, INCLUSIVE}
+  }
+  return w.buffer.lines(from, to)
+}
+
+// The text an operator acts on over count words, or WORDs, which unlike the
+// w motion runs to the end of the buffer when there is no word after, and
+// stops at the end of the line the last word moved over is on rather than
+// going on to the start of the next line, as in vim.
+func (w *Window) Words(count int, WORD bool) Range {
+  from := w.cur
+  if from == nil {
+    return Range{}
+  }
+  p, last := from, from
+  for i := 0; (i < count || i == 0) && p != nil; i++ {
+    last, p = p, nextWord(p, WORD)
+  }
+  for q := last; q != p; q = q.next {
+    if EOL(q.c) {
+      p = q
+      break
+    }
+  }
+  if p == nil {
+    return Range{from, w.buffer.tail, INCLUSIVE}
+  } else if p == from {
+    return Range{}
+  }
+  return Range{from, p.prev, INCLUSIVE}
+}
+
+// The lines p and q are on, and those between.
+func (b *Buffer) lines(p, q *node) Range {
+  if p == nil {
+    p = b.tail
+  }
+  if q == nil {
+    q = b.tail
+  }
+  if p == nil {
+    return Range{}
+  }
+  for p.prev != nil && !EOL(p.prev.c) {
+    p = p.prev
+  }
+  for q.next != nil && !EOL(q.c) {
+    q = q.next
+  }
+  return Range{p, q, LINEWISE}
+}
+
+// The cursor's line and the count - 1 lines after it.
+func (w *Window) Lines(count int) Range {
+  q := w.cur
+  for i := 1; i < count && q != nil; i++ {
+    for q.next != nil && !EOL(q.c) {
+      q = q.next
+    }
+    if q.next == nil {
+      break
+    }
+    q = q.next
+  }
+  return w.buffer.lines(w.cur, q)
+}
+
+// The selection, or the rune at the cursor if there isn't one.
+func (w *Window) Selection() Range {
+  if w.mark == nil {
+    return Range{w.cur, w.cur, INCLUSIVE}
+  }
+  first, last := w.marked()
+  if last == nil {
+    last = w.buffer.tail
+  }
+  if w.markKind == LINEWISE {
+    return w.buffer.lines(first, last)
+  }
+  return Range{first, last, w.markKind}
+}
+
+// Selects r.
+func (w *Window) Select(r Range) {
+  if r.Empty() {
+    return
+  }
+  w.mark, w.cur, w.markKind = r.first, r.last, r.Kind
+  if r.Kind != BLOCKWISE {
+    w.markKind = INCLUSIVE
+  }
+}
+
+// Applies an operator to r: 'd' deletes it, 'c' deletes it but for the end of
+// a linewise range, 'y' copies it, '>' and '<' indent and unindent its lines,
+// 'q' reflows them and 'z' folds them. Returns the text deleted or copied.
+func (w *Window) Apply(op rune, r Range) string {
+  if r.Empty() {
+    return ""
+  }
+  if r.Kind == BLOCKWISE && op != '>' && op != '<' && op != 'q' && op != 'z' {
+    return w.applyBlock(op, r)
+  }
+  switch (op) {
+  case 'c':
+    if r.Kind == LINEWISE && EOL(r.last.c) {
+      if r.first == r.last {
+        w.cur = r.first
+        return ""
+      }
+      r.last = r.last.prev
+    }
+    fallthrough
+  case 'd':
+    w.Select(r)
+    return w.Delete()
+  case 'y':
+    w.Select(r)
+    return w.Yank()
+  case '>', '<':
+    w.shift(r, op == '>')
+  case 'q':
+    w.Reflow(r)
+  case 'z':
+    w.Fold(r)
+  }
+  return ""
+}
+
+// Indents each line of r that isn't empty by a tab, or a tab's width of spaces
+// with Config.ExpandTab, or if in isn't set, takes away a tab or a tab's width
+// of spaces from the start of each line.
+// Leaves the cursor at the start of the first line.
+func (w *Window) shift(r Range, in bool) {
+  r = w.buffer.lines(r.first, r.last)
+  var starts []*node
+  for pos := r.first; pos != nil; pos = pos.next {
+    if pos == r.first || EOL(pos.prev.c) {
+      starts = append(starts, pos)
+    }
+    if pos == r.last {
+      break
+    }
+  }
+  width := w.buffer.tabWidth()
+  for k, p := range starts {
+    if in {
+      if !EOL(p.c) {
+        starts[k] = w.buffer.insertIndent(p)
+      }
+      continue
+    }
+    limit := width
+    if p.c == '\t' {
+      limit = 1
+    }
+    for n := 0; p != nil && n < limit && (p.c == ' ' || p.c == '\t'); n++ {
+      next := p.next
+      w.buffer.remove(p)
+      p = next
+    }
+    starts[k] = p
+  }
+  w.mark = nil
+  if len(starts) > 0 && starts[0] != nil {
+    w.cur = starts[0]
+  }
+}
@@ -0,0 +1,159 @@
+package buffer
+
+import (
+  "regexp"
+  "strings"
+)
+
+// The text from first up to last, or to the end if last is nil, along with
+// the node each byte of it came from. One more entry, last, marks the end.
+func span(first, last *node) (string, []*node) {
+  var builder strings.Builder
+  var nodes []*node
+  for pos := first; pos != last; pos = pos.next {
+    builder.WriteRune(pos.c)
+    for len(nodes) < builder.Len() {
+      nodes = append(nodes, pos)
+    }
+  }
+  return builder.String(), append(nodes, last)
+}
+
+// The byte offset of p in text returned by span.
+func offset(nodes []*node, p *node) int {
+  for k, n := range nodes {
+    if n == p {
+      return k
+    }
+  }
+  return len(nodes) - 1
+}
+
+// Moves the cursor to the next match of pat, wrapping around the end.
+// Returns false if there is no match anywhere.
+func (w *Window) Find(pat *regexp.Regexp) bool {
+  text, nodes := span(w.buffer.head, nil)
+  cur := offset(nodes, w.cur)
+  matches := pat.FindAllStringIndex(text, -1)
+  if len(matches) == 0 {
+    return false
+  }
+  at := matches[0][0]
+  for _, m := range matches {
+    if m[0] > cur {
+      at = m[0]
+      break
+    }
+  }
+  w.cur = nodes[at]
+  w.reveal()
+  return true
+}
+
+// Moves the cursor to the previous match of pat, wrapping around the start.
+func (w *Window) FindReverse(pat *regexp.Regexp) bool {
+  text, nodes := span(w.buffer.head, nil)
+  cur := offset(nodes, w.cur)
+  matches := pat.FindAllStringIndex(text, -1)
+  if len(matches) == 0 {
+    return false
+  }
+  at := matches[len(matches) - 1][0]
+  for k := len(matches) - 1; k >= 0; k-- {
+    if matches[k][0] < cur {
+      at = matches[k][0]
+      break
+    }
+  }
+  w.cur = nodes[at]
+  w.reveal()
+  return true
+}
+
+// Replaces matches of pat with repl, in which $1 and the like expand to
+// submatches. Replaces matches in the selection if there is one, otherwise on
+// the cursor's line, or everywhere if whole is set. Unless global is set, only
+// the first match on each line is replaced.
+//
+// If confirm is given, it is asked about each match, which is selected while it
+// asks: 'y' replaces the match, 'n' skips it, 'a' replaces it and all the rest,
+// and anything else stops. The replacements undo as one change. Returns how
+// many were made.
+func (w *Window) Substitute(pat *regexp.Regexp, repl string, whole, global bool, confirm func() rune) int {
+  first, last := w.buffer.head, (*node)(nil)
+  if !whole && w.mark != nil {
+    r := w.Selection()
+    first, last = r.first, r.last
+    if last != nil {
+      last = last.next
+    }
+  } else if !whole && w.cur != nil {
+    first = w.cur
+    if first.prev != nil && !EOL(first.prev.c) {
+      first, _ = first.seekback(EOL)
+    }
+    last = first
+    for last != nil && !EOL(last.c) {
+      last = last.next
+    }
+    if last != nil {
+      last = last.next
+    }
+  }
+  text, nodes := span(first, last)
+  back := w.NewMarker()
+  defer back.Release()
+  w.buffer.BeginChange()
+  defer w.buffer.EndChange()
+  all := confirm == nil
+  n := 0
+  line, lines, counted := -1, 0, 0
+  var at *node
+loop:
+  for _, m := range pat.FindAllStringSubmatchIndex(text, -1) {
+    lines += strings.Count(text[counted:m[0]], "\n")
+    counted = m[0]
+    if !global && lines == line {
+      continue
+    }
+    if !all {
+      // the selection ends on the match's last rune, and an empty match
+      // selects nothing
+      w.mark, w.cur, w.markKind = nodes[m[0]], nodes[m[0]], INCLUSIVE
+      if m[1] > m[0] {
+        w.cur = nodes[m[1] - 1]
+      } else {
+        w.mark = nil
+      }
+      switch (confirm()) {
+      case 'y':
+      case 'a': all = true
+      case 'n': continue
+      default: break loop
+      }
+    }
+    for k := m[0]; k < m[1]; k++ {
+      if k == m[0] || nodes[k] != nodes[k - 1] {
+        w.buffer.remove(nodes[k])
+      }
+    }
+    at = nil
+    for _, c := range string(pat.ExpandString(nil, repl, text, m)) {
+      p := w.buffer.insertBefore(nodes[m[1]], c)
+      if at == nil {
+        at = p
+      }
+    }
+    if at == nil {
+      at = nodes[m[1]]
+    }
+    line = lines
+    n++
+  }
+  w.mark = nil
+  if n > 0 {
+    back.pos = at
+  }
+  w.Jump(back)
+  return n
+}
@@ -0,0 +1,35 @@
+package buffer
+
+import (
+  "regexp"
+  "testing"
+)
+
+// While confirming, just the match is highlighted.
+func TestConfirmSelects(t *testing.T) {
+  for _, test := range []struct {
+    text, pat, want string
+  }{
+    {"xx foo yy\n", "foo", "foo"},
+    {"xx héé yy\n", "hé+", "héé"},
+    {"xx foo yy\n", "o*$", ""},
+  } {
+    b := &Buffer{}
+    b.AppendString(test.text)
+    w := b.Window("test", 10, 80)
+    var selected []string
+    w.Substitute(regexp.MustCompile(test.pat), "", true, false, func() rune {
+      text := ""
+      if r := w.Selection(); w.mark != nil {
+        for p := r.first; p != r.last.next; p = p.next {
+          text += string(p.c)
+        }
+      }
+      selected = append(selected, text)
+      return 'n'
+    })
+    if len(selected) == 0 || selected[0] != test.want {
+      t.Errorf("/%s/ in %q selected %q, want %q", test.pat, test.text, selected, test.want)
+    }
+  }
+}
@@ -0,0 +1,81 @@
+package buffer
+
+import (
+  "../raster"
+  "sort"
+)
+
+// A mark drawn beside a line, such as where a compiler complained about it.
+type Sign struct {
+  // counting from 1
+  Line int
+  // if set, the sign follows the Marker's line instead
+  At *Marker
+  Mark rune
+  Style raster.Style
+  Text string
+}
+
+// Replaces the signs put up by source. Unless their Gutter says otherwise,
+// windows showing any signs leave a column for them on the left.
+func (b *Buffer) SetSigns(source string, signs []Sign) {
+  if len(signs) == 0 {
+    delete(b.signs, source)
+    return
+  }
+  if b.signs == nil {
+    b.signs = make(map[string][]Sign)
+  }
+  b.signs[source] = signs
+}
+
+// The signs on a line, ordered by their source's name.
+func (b *Buffer) SignsAt(line int) (signs []Sign) {
+  for _, source := range b.sources() {
+    for _, s := range b.signs[source] {
+      if s.At != nil && s.At.pos != nil && b.lineOf(s.At.pos) == line || s.At == nil && s.Line == line {
+        signs = append(signs, s)
+      }
+    }
+  }
+  return
+}
+
+func (b *Buffer) sources() []string {
+  var sources []string
+  for source := range b.signs {
+    sources = append(sources, source)
+  }
+  sort.Strings(sources)
+  return sources
+}
+
+// The sign to draw beside each line from first on, where starts has the
+// line numbers of the lines on screen, for placing signs At Markers. Where
+// there is more than one, the first of SignsAt wins.
+func (b *Buffer) signsFrom(first int, starts map[*node]int) map[int]Sign {
+  shown := make(map[int]Sign)
+  for _, source := range b.sources() {
+    for _, s := range b.signs[source] {
+      line := s.Line
+      if s.At != nil {
+        line = starts[lineStart(s.At.pos)]
+      }
+      if _, ok := shown[line]; !ok && line >= first && line > 0 {
+        shown[line] = s
+      }
+    }
+  }
+  return shown
+}
+
+// The line p is on, counting from 1.
+func (b *Buffer) lineOf(p *node) int {
+  line := 1
+  for pos := b.head; pos != nil && pos != p; pos = pos.next {
+    if EOL(pos.c) {
+      line++
+    }
+  }
+  return line
+}
@@ -0,0 +1,95 @@
+package buffer
+
+// A rune linked into or out of the list. Nodes keep their prev and next when
+// unlinked, so undoing edits in reverse order puts every node back where it was.
+type edit struct {
+  n *node
+  inserted bool
+}
+
+// Edits that are undone and redone together.
+type change []edit
+
+// Starts a change. Edits until the matching EndChange undo as one. Changes
+// nest, and edits made outside of any change undo one rune at a time.
+func (b *Buffer) BeginChange() {
+  b.depth++
+}
+
+func (b *Buffer) EndChange() {
+  if b.depth == 0 {
+    return
+  }
+  b.depth--
+  if b.depth == 0 && len(b.change) > 0 {
+    b.undo = append(b.undo, b.change)
+    b.change = nil
+  }
+}
+
+func (b *Buffer) record(e edit) {
+  b.redo = nil
+  if b.depth > 0 {
+    b.change = append(b.change, e)
+  } else {
+    b.undo = append(b.undo, change{e})
+  }
+}
+
+// Reverts the last change, returning where it happened.
+func (b *Buffer) undoLast() (at *node, ok bool) {
+  if len(b.undo) == 0 {
+    return nil, false
+  }
+  c := b.undo[len(b.undo) - 1]
+  b.undo = b.undo[:len(b.undo) - 1]
+  for k := len(c) - 1; k >= 0; k-- {
+    if c[k].inserted {
+      b.unlink(c[k].n)
+      at = c[k].n.next
+    } else {
+      b.link(c[k].n)
+      at = c[k].n
+    }
+  }
+  b.redo = append(b.redo, c)
+  return at, true
+}
+
+// Makes the last undone change again.
+func (b *Buffer) redoLast() (at *node, ok bool) {
+  if len(b.redo) == 0 {
+    return nil, false
+  }
+  c := b.redo[len(b.redo) - 1]
+  b.redo = b.redo[:len(b.redo) - 1]
+  for _, e := range c {
+    if e.inserted {
+      b.link(e.n)
+    } else {
+      b.unlink(e.n)
+    }
+    at = e.n.next
+  }
+  b.undo = append(b.undo, c)
+  return at, true
+}
+
+// Undoes the last change to the window's buffer, moving the cursor to it.
+func (w *Window) Undo() bool {
+  at, ok := w.buffer.undoLast()
+  if ok {
+    w.cur = at
+    w.reveal()
+  }
+  return ok
+}
+
+func (w *Window) Redo() bool {
+  at, ok := w.buffer.redoLast()
+  if ok {
+    w.cur = at
+    w.reveal()
+  }
+  return ok
+}
@@ -0,0 +1,611 @@
+// A sam-style command language for buffers.
+//
+// A command is an optional address followed by a command letter:
+//
+//   n        line n           #n      the empty range after rune n
+//   $        the end          .       dot, the current range
+//   /re/     the next match   ?re?    the previous match
+//   a1,a2    a1 through a2    a1;a2   the same, with a2 found from a1
+//   a1+a2    a2 after a1      a1-a2   a2 before a1, where a2 is a line count
+//                                     or #n, or a pattern
+//
+//   p            print              d            delete
+//   a/text/      append after       i/text/      insert before
+//   c/text/      change to text     s/re/text/g  substitute
+//   m a          move to after a    t a          copy to after a
+//   g/re/ cmd    run cmd if dot matches re, v/re/ cmd if it doesn't
+//   x/re/ cmd    run cmd on each match in dot
+//   y/re/ cmd    run cmd on each piece between matches in dot
+//
+// A command with no letter sets dot to its address.
+package ed
+
+import (
+  "../buffer"
+  "fmt"
+  "io"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+)
+
+// A range of runes, from q0 up to q1.
+type Range struct {
+  Q0, Q1 int
+}
+
+type addr struct {
+  // one of l (line), # (rune), $, ., /, ?, and the operators , ; + -
+  kind rune
+  n int
+  re *regexp.Regexp
+  left, right *addr
+}
+
+type cmd struct {
+  addr *addr
+  name rune
+  text string
+  re *regexp.Regexp
+  global bool
+  to *addr
+  sub *cmd
+}
+
+// Runs commands against a buffer, remembering dot between them.
+type Editor struct {
+  buf *buffer.Buffer
+  out io.Writer
+  dot Range
+  // the buffer's text, kept in step with it
+  text *text
+  // how many x and y loops deep we are
+  looping int
+}
+
+// Returns an Editor on b, printing to out, with dot at the start.
+func New(b *buffer.Buffer, out io.Writer) *Editor {
+  return &Editor{buf: b, out: out}
+}
+
+func (e *Editor) Dot() Range {
+  return e.dot
+}
+
+func (e *Editor) SetDot(r Range) {
+  e.dot = r
+}
+
+// Parses and runs one command. Its changes undo together.
+func (e *Editor) Run(line string) error {
+  p := &parser{s: []rune(line)}
+  c, err := p.command()
+  if err != nil {
+    return err
+  }
+  if p.skipSpace(); p.k < len(p.s) {
+    return fmt.Errorf("unexpected %q", string(p.s[p.k:]))
+  }
+  e.text = newText(e.buf.String())
+  if e.dot.Q1 > e.text.len() {
+    e.dot = Range{e.text.len(), e.text.len()}
+  }
+  e.buf.BeginChange()
+  defer e.buf.EndChange()
+  return e.exec(c)
+}
+
+// Replaces r with s in both the buffer and our copy of its text.
+func (e *Editor) replace(r Range, s string) {
+  e.buf.Replace(r.Q0, r.Q1, s)
+  e.text.replace(r.Q0, r.Q1, []rune(s))
+}
+
+func (e *Editor) exec(c *cmd) error {
+  dot := e.dot
+  if c.addr != nil {
+    var err error
+    if dot, err = e.eval(c.addr, e.dot); err != nil {
+      return err
+    }
+  }
+  e.dot = dot
+  switch (c.name) {
+  case 0:
+  case 'p':
+    io.WriteString(e.out, e.text.slice(dot.Q0, dot.Q1))
+  case 'd':
+    e.replace(dot, "")
+    e.dot = Range{dot.Q0, dot.Q0}
+  case 'a':
+    e.replace(Range{dot.Q1, dot.Q1}, c.text)
+    e.dot = Range{dot.Q1, dot.Q1 + len([]rune(c.text))}
+  case 'i':
+    e.replace(Range{dot.Q0, dot.Q0}, c.text)
+    e.dot = Range{dot.Q0, dot.Q0 + len([]rune(c.text))}
+  case 'c':
+    e.replace(dot, c.text)
+    e.dot = Range{dot.Q0, dot.Q0 + len([]rune(c.text))}
+  case 's':
+    return e.substitute(c, dot)
+  case 'm', 't':
+    return e.transfer(c, dot)
+  case 'g', 'v':
+    if c.re.MatchString(e.text.slice(dot.Q0, dot.Q1)) == (c.name == 'g') {
+      return e.exec(c.sub)
+    }
+  case 'x', 'y':
+    return e.loop(c, dot)
+  }
+  return nil
+}
+
+// Rune offsets of the matches of re in r.
+func (e *Editor) matches(re *regexp.Regexp, r Range) []Range {
+  s := e.text.slice(r.Q0, r.Q1)
+  var found []Range
+  // counting runes on from the last match, not from the start each time
+  q, at := r.Q0, 0
+  for _, m := range re.FindAllStringIndex(s, -1) {
+    q += utf8.RuneCountInString(s[at:m[0]])
+    found = append(found, Range{q, q + utf8.RuneCountInString(s[m[0]:m[1]])})
+    q, at = found[len(found) - 1].Q1, m[1]
+  }
+  return found
+}
+
+func (e *Editor) substitute(c *cmd, dot Range) error {
+  s := e.text.slice(dot.Q0, dot.Q1)
+  found := c.re.FindAllStringSubmatchIndex(s, -1)
+  if len(found) == 0 {
+    if e.looping > 0 {
+      // pieces without a match are just left alone
+      return nil
+    }
+    return fmt.Errorf("no match for %s", c.re)
+  }
+  if !c.global {
+    found = found[:1]
+  }
+  // the rune offsets of each match, counted on from the one before
+  at := make([]Range, len(found))
+  q, b := dot.Q0, 0
+  for k, m := range found {
+    q += utf8.RuneCountInString(s[b:m[0]])
+    at[k] = Range{q, q + utf8.RuneCountInString(s[m[0]:m[1]])}
+    q, b = at[k].Q1, m[1]
+  }
+  // last first, so the earlier offsets stay good
+  end := dot.Q1
+  for k := len(found) - 1; k >= 0; k-- {
+    m := found[k]
+    q0, q1 := at[k].Q0, at[k].Q1
+    repl := string(c.re.ExpandString(nil, c.text, s, m))
+    e.replace(Range{q0, q1}, repl)
+    end += len([]rune(repl)) - (q1 - q0)
+  }
+  e.dot = Range{dot.Q0, end}
+  return nil
+}
+
+func (e *Editor) transfer(c *cmd, dot Range) error {
+  to, err := e.eval(c.to, dot)
+  if err != nil {
+    return err
+  }
+  q := to.Q1
+  text := e.text.slice(dot.Q0, dot.Q1)
+  n := dot.Q1 - dot.Q0
+  switch {
+  case c.name == 't':
+    e.replace(Range{q, q}, text)
+    e.dot = Range{q, q + n}
+  case q > dot.Q0 && q < dot.Q1:
+    return fmt.Errorf("can't move text into itself")
+  case q >= dot.Q1:
+    e.replace(Range{q, q}, text)
+    e.replace(dot, "")
+    e.dot = Range{q - n, q}
+  default:
+    e.replace(dot, "")
+    e.replace(Range{q, q}, text)
+    e.dot = Range{q, q + n}
+  }
+  return nil
+}
+
+// Runs c.sub on each match of c.re in dot, for x, or each piece between
+// matches, for y. Later pieces shift by however much earlier ones grew.
+func (e *Editor) loop(c *cmd, dot Range) error {
+  found := e.matches(c.re, dot)
+  pieces := found
+  if c.name == 'y' {
+    pieces = nil
+    q := dot.Q0
+    for _, m := range found {
+      pieces = append(pieces, Range{q, m.Q0})
+      q = m.Q1
+    }
+    if q < dot.Q1 {
+      pieces = append(pieces, Range{q, dot.Q1})
+    }
+  }
+  e.looping++
+  defer func() {
+    e.looping--
+  }()
+  shift := 0
+  for _, r := range pieces {
+    before := e.text.len()
+    e.dot = Range{r.Q0 + shift, r.Q1 + shift}
+    if err := e.exec(c.sub); err != nil {
+      return err
+    }
+    shift += e.text.len() - before
+  }
+  e.dot = Range{dot.Q0, dot.Q1 + shift}
+  return nil
+}
+
+// Where the nth line starts, counting the line q is on as the first.
+func (e *Editor) lineStart(q, n int) (int, error) {
+  for ; n > 1; n-- {
+    for q < e.text.len() && e.text.at(q) != '\n' {
+      q++
+    }
+    if q == e.text.len() {
+      return 0, fmt.Errorf("address out of range")
+    }
+    q++
+  }
+  return q, nil
+}
+
+// The line starting at q, with its newline.
+func (e *Editor) lineFrom(q int) Range {
+  r := Range{q, q}
+  for r.Q1 < e.text.len() && e.text.at(r.Q1) != '\n' {
+    r.Q1++
+  }
+  if r.Q1 < e.text.len() {
+    r.Q1++
+  }
+  return r
+}
+
+func (e *Editor) eval(a *addr, dot Range) (Range, error) {
+  end := e.text.len()
+  switch (a.kind) {
+  case 'l':
+    if a.n == 0 {
+      return Range{0, 0}, nil
+    }
+    q, err := e.lineStart(0, a.n)
+    return e.lineFrom(q), err
+  case '#':
+    if a.n > end {
+      return dot, fmt.Errorf("address out of range")
+    }
+    return Range{a.n, a.n}, nil
+  case '$':
+    return Range{end, end}, nil
+  case '.':
+    return dot, nil
+  case '/':
+    return e.search(a.re, dot.Q1, true)
+  case '?':
+    return e.search(a.re, dot.Q0, false)
+  case ',', ';':
+    left, right := Range{0, 0}, Range{end, end}
+    var err error
+    if a.left != nil {
+      if left, err = e.eval(a.left, dot); err != nil {
+        return dot, err
+      }
+    }
+    if a.kind == ';' {
+      dot = left
+    }
+    if a.right != nil {
+      if right, err = e.eval(a.right, dot); err != nil {
+        return dot, err
+      }
+    }
+    if right.Q1 < left.Q0 {
+      return dot, fmt.Errorf("addresses out of order")
+    }
+    return Range{left.Q0, right.Q1}, nil
+  case '+', '-':
+    from := dot
+    if a.left != nil {
+      var err error
+      if from, err = e.eval(a.left, dot); err != nil {
+        return dot, err
+      }
+    }
+    return e.relative(a, from)
+  }
+  return dot, fmt.Errorf("bad address")
+}
+
+// Evaluates the right side of + or - relative to from.
+func (e *Editor) relative(a *addr, from Range) (Range, error) {
+  r := a.right
+  if r == nil {
+    r = &addr{kind: 'l', n: 1}
+  }
+  forward := a.kind == '+'
+  switch (r.kind) {
+  case 'l':
+    if forward {
+      q := from.Q1
+      if q == from.Q0 || e.text.at(q - 1) != '\n' {
+        // from ends partway through a line, so count from the next one
+        for q < e.text.len() && e.text.at(q) != '\n' {
+          q++
+        }
+        if q == e.text.len() {
+          return from, fmt.Errorf("address out of range")
+        }
+        q++
+      }
+      q, err := e.lineStart(q, r.n)
+      return e.lineFrom(q), err
+    }
+    q := from.Q0
+    for q > 0 && e.text.at(q - 1) != '\n' {
+      q--
+    }
+    for n := r.n; n > 0; n-- {
+      if q == 0 {
+        return from, fmt.Errorf("address out of range")
+      }
+      q--
+      for q > 0 && e.text.at(q - 1) != '\n' {
+        q--
+      }
+    }
+    return e.lineFrom(q), nil
+  case '#':
+    q := from.Q1 + r.n
+    if !forward {
+      q = from.Q0 - r.n
+    }
+    if q < 0 || q > e.text.len() {
+      return from, fmt.Errorf("address out of range")
+    }
+    return Range{q, q}, nil
+  case '/', '?':
+    if forward {
+      return e.search(r.re, from.Q1, true)
+    }
+    return e.search(r.re, from.Q0, false)
+  }
+  return e.eval(r, from)
+}
+
+// Finds the first match of re after q, or the last one before it, wrapping
+// around the ends.
+func (e *Editor) search(re *regexp.Regexp, q int, forward bool) (Range, error) {
+  all := e.matches(re, Range{0, e.text.len()})
+  if len(all) == 0 {
+    return Range{q, q}, fmt.Errorf("no match for %s", re)
+  }
+  if forward {
+    for _, m := range all {
+      if m.Q0 >= q && m.Q1 > q {
+        return m, nil
+      }
+    }
+    return all[0], nil
+  }
+  k := sort.Search(len(all), func(k int) bool {
+    return all[k].Q1 >= q
+  })
+  if k == 0 {
+    return all[len(all) - 1], nil
+  }
+  return all[k - 1], nil
+}
+
+type parser struct {
+  s []rune
+  k int
+}
+
+func (p *parser) peek() rune {
+  if p.k < len(p.s) {
+    return p.s[p.k]
+  }
+  return 0
+}
+
+func (p *parser) skipSpace() {
+  for p.k < len(p.s) && (p.s[p.k] == ' ' || p.s[p.k] == '\t') {
+    p.k++
+  }
+}
+
+func (p *parser) number() int {
+  start := p.k
+  for p.k < len(p.s) && unicode.IsDigit(p.s[p.k]) {
+    p.k++
+  }
+  n, _ := strconv.Atoi(string(p.s[start:p.k]))
+  return n
+}
+
+// Reads up to the next unescaped delim, turning \delim into delim and \n
+// into a newline if the text isn't a pattern.
+func (p *parser) delimited(delim rune, pattern bool) (string, error) {
+  var out []rune
+  for ; p.k < len(p.s); p.k++ {
+    c := p.s[p.k]
+    if c == delim {
+      p.k++
+      return string(out), nil
+    }
+    if c == '\\' && p.k + 1 < len(p.s) {
+      next := p.s[p.k + 1]
+      if next == delim {
+        out = append(out, delim)
+        p.k++
+        continue
+      } else if next == 'n' && !pattern {
+        out = append(out, '\n')
+        p.k++
+        continue
+      }
+    }
+    out = append(out, c)
+  }
+  // like sam, the last delimiter may be left off
+  return string(out), nil
+}
+
+func (p *parser) regexp(delim rune) (*regexp.Regexp, error) {
+  s, err := p.delimited(delim, true)
+  if err != nil {
+    return nil, err
+  }
+  return regexp.Compile("(?m)" + s)
+}
+
+// Reads a delimiter for a pattern or text argument.
+func (p *parser) delim() (rune, error) {
+  p.skipSpace()
+  c := p.peek()
+  if c == 0 || unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.IsSpace(c) {
+    return 0, fmt.Errorf("expected a delimiter")
+  }
+  p.k++
+  return c, nil
+}
+
+func (p *parser) simple() (*addr, error) {
+  p.skipSpace()
+  switch c := p.peek(); {
+  case unicode.IsDigit(c):
+    return &addr{kind: 'l', n: p.number()}, nil
+  case c == '#':
+    p.k++
+    return &addr{kind: '#', n: p.number()}, nil
+  case c == '$' || c == '.':
+    p.k++
+    return &addr{kind: c}, nil
+  case c == '/' || c == '?':
+    p.k++
+    re, err := p.regexp(c)
+    return &addr{kind: c, re: re}, err
+  }
+  return nil, nil
+}
+
+// addr := simple { (+|-) [simple] } [ (,|;) [addr] ]
+func (p *parser) address() (*addr, error) {
+  a, err := p.simple()
+  if err != nil {
+    return nil, err
+  }
+  for {
+    p.skipSpace()
+    c := p.peek()
+    if c != '+' && c != '-' {
+      break
+    }
+    p.k++
+    right, err := p.simple()
+    if err != nil {
+      return nil, err
+    }
+    a = &addr{kind: c, left: a, right: right}
+  }
+  if c := p.peek(); c == ',' || c == ';' {
+    p.k++
+    right, err := p.address()
+    if err != nil {
+      return nil, err
+    }
+    a = &addr{kind: c, left: a, right: right}
+  }
+  return a, nil
+}
+
+func (p *parser) command() (*cmd, error) {
+  a, err := p.address()
+  if err != nil {
+    return nil, err
+  }
+  c := &cmd{addr: a}
+  p.skipSpace()
+  c.name = p.peek()
+  if c.name == 0 {
+    return c, nil
+  }
+  p.k++
+  switch (c.name) {
+  case 'p', 'd':
+  case 'a', 'i', 'c':
+    delim, err := p.delim()
+    if err != nil {
+      return nil, err
+    }
+    c.text, err = p.delimited(delim, false)
+    return c, err
+  case 's':
+    delim, err := p.delim()
+    if err != nil {
+      return nil, err
+    }
+    if c.re, err = p.regexp(delim); err != nil {
+      return nil, err
+    }
+    if c.text, err = p.delimited(delim, false); err != nil {
+      return nil, err
+    }
+    if p.peek() == 'g' {
+      c.global = true
+      p.k++
+    }
+  case 'm', 't':
+    if c.to, err = p.address(); err != nil {
+      return nil, err
+    }
+    if c.to == nil {
+      return nil, fmt.Errorf("%c needs an address", c.name)
+    }
+  case 'g', 'v', 'x', 'y':
+    delim, err := p.delim()
+    if err != nil {
+      return nil, err
+    }
+    if c.re, err = p.regexp(delim); err != nil {
+      return nil, err
+    }
+    if c.sub, err = p.command(); err != nil {
+      return nil, err
+    }
+  default:
+    return nil, fmt.Errorf("unknown command %c", c.name)
+  }
+  return c, nil
+}
+
+// Runs each line of script as a command, stopping at the first error, which
+// is reported with its line number. Blank lines, and lines starting with "# ",
+// are skipped.
+func (e *Editor) RunScript(script string) error {
+  for n, line := range strings.Split(script, "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || line == "#" || strings.HasPrefix(line, "# ") {
+      continue
+    }
+    if err := e.Run(line); err != nil {
+      return fmt.Errorf("line %d: %v", n + 1, err)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,166 @@
+package ed
+
+import (
+  "../buffer"
+  "bytes"
+  "strings"
+  "testing"
+)
+
+const lines = "one\ntwo\nthree\nfour\n"
+
+// Runs each command on a buffer holding text, returning what the buffer
+// holds after and what was printed, or the first error.
+func run(text string, commands ...string) (string, string, error) {
+  b := &buffer.Buffer{}
+  b.AppendString(text)
+  var out bytes.Buffer
+  e := New(b, &out)
+  for _, c := range commands {
+    if err := e.Run(c); err != nil {
+      return b.String(), out.String(), err
+    }
+  }
+  return b.String(), out.String(), nil
+}
+
+func TestAddresses(t *testing.T) {
+  for _, test := range []struct {
+    text, addr, want string
+  }{
+    {lines, "2", "two\n"},
+    {lines, "2,3", "two\nthree\n"},
+    {lines, ",", lines},
+    {lines, "$", ""},
+    {lines, "#4,#7", "two"},
+    {lines, "/t/", "t"},
+    {lines, "/th/,/f/", "three\nf"},
+    {lines, "3;+1", "three\nfour\n"},
+    {lines, "3-1", "two\n"},
+    {lines, "3-#2", ""},
+    {lines, "1+/o/", "o"},
+    {lines, "$-/o/", "o"},
+    {lines, "0", ""},
+    // searches wrap around the ends
+    {lines, "?our?", "our"},
+    {lines, "$-/one/", "one"},
+  } {
+    _, got, err := run(test.text, test.addr + "p")
+    if err != nil || got != test.want {
+      t.Errorf("%sp gave %q, %v, want %q", test.addr, got, err, test.want)
+    }
+  }
+}
+
+func TestCommands(t *testing.T) {
+  for _, test := range []struct {
+    commands []string
+    want string
+  }{
+    {[]string{"2d"}, "one\nthree\nfour\n"},
+    {[]string{"2a/new\\n/"}, "one\ntwo\nnew\nthree\nfour\n"},
+    {[]string{"2i/new\\n/"}, "one\nnew\ntwo\nthree\nfour\n"},
+    {[]string{"2c/TWO\\n/"}, "one\nTWO\nthree\nfour\n"},
+    {[]string{"2c,x,"}, "one\nxthree\nfour\n"},
+    {[]string{",s/o/0/"}, "0ne\ntwo\nthree\nfour\n"},
+    {[]string{",s/o/0/g"}, "0ne\ntw0\nthree\nf0ur\n"},
+    {[]string{",s/(t)(\\w+)/${2}$1/g"}, "one\nwot\nhreet\nfour\n"},
+    {[]string{"1m3"}, "two\nthree\none\nfour\n"},
+    {[]string{"3m0"}, "three\none\ntwo\nfour\n"},
+    {[]string{"1t$"}, "one\ntwo\nthree\nfour\none\n"},
+    {[]string{",x/o/c/0/"}, "0ne\ntw0\nthree\nf0ur\n"},
+    {[]string{",x/[a-z]+/g/e/d"}, "\ntwo\n\nfour\n"},
+    {[]string{",x/[a-z]+/v/e/d"}, "one\n\nthree\n\n"},
+    {[]string{",y/\\n/c/x/"}, "x\nx\nx\nx\n"},
+    {[]string{",x/.*\\n/x/o/d"}, "ne\ntw\nthree\nfur\n"},
+    // dot carries over from one command to the next
+    {[]string{"2", "d"}, "one\nthree\nfour\n"},
+    {[]string{"/three/", "+1d"}, "one\ntwo\nthree\n"},
+  } {
+    got, _, err := run(lines, test.commands...)
+    if err != nil || got != test.want {
+      t.Errorf("%q gave %q, %v, want %q", test.commands, got, err, test.want)
+    }
+  }
+}
+
+func TestErrors(t *testing.T) {
+  for _, c := range []string{
+    "9p",
+    "#99p",
+    "3,1p",
+    "/nothing/p",
+    ",s/nothing/x/",
+    "2,3m2",
+    "m",
+    "2k",
+    "a",
+    "1p junk",
+    // wrapping around makes the range run backwards
+    "4;/one/p",
+  } {
+    got, _, err := run(lines, c)
+    if err == nil {
+      t.Errorf("%q gave no error", c)
+    }
+    if got != lines {
+      t.Errorf("%q left %q after failing", c, got)
+    }
+  }
+}
+
+func TestUndo(t *testing.T) {
+  b := &buffer.Buffer{}
+  b.AppendString(lines)
+  w := b.Window("test", 10, 80)
+  e := New(b, &bytes.Buffer{})
+  if err := e.Run(",x/o/c/00/"); err != nil {
+    t.Fatal(err)
+  }
+  // the whole command undoes at once
+  w.Undo()
+  if got := b.String(); got != lines {
+    t.Errorf("undo left %q", got)
+  }
+}
+
+func TestScript(t *testing.T) {
+  b := &buffer.Buffer{}
+  b.AppendString(lines)
+  var out bytes.Buffer
+  err := New(b, &out).RunScript("# a comment\n\n,s/one/1/\n  $-1p\n")
+  if got := b.String(); err != nil || got != "1\ntwo\nthree\nfour\n" || out.String() != "four\n" {
+    t.Errorf("got %q printing %q, %v", got, out.String(), err)
+  }
+  err = New(b, &out).RunScript("1d\n9d\n")
+  if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+    t.Errorf("got %v, want an error on line 2", err)
+  }
+}
+
+func TestText(t *testing.T) {
+  want := []rune("hello, world")
+  x := newText(string(want))
+  for _, edit := range []struct {
+    q0, q1 int
+    s string
+  }{
+    {0, 5, "goodbye"},
+    {len("goodbye, "), len("goodbye, world"), "moon"},
+    {3, 3, "ééé"},
+    {0, 0, strings.Repeat("x", 100)},
+    {50, 110, ""},
+  } {
+    rest := append([]rune(string(edit.s)), want[edit.q1:]...)
+    want = append(want[:edit.q0:edit.q0], rest...)
+    x.replace(edit.q0, edit.q1, []rune(edit.s))
+    if got := x.slice(0, x.len()); got != string(want) {
+      t.Fatalf("after replacing %d-%d with %q, got %q, want %q", edit.q0, edit.q1, edit.s, got, string(want))
+    }
+    for q := range want {
+      if x.at(q) != want[q] {
+        t.Fatalf("rune %d is %q, want %q", q, x.at(q), want[q])
+      }
+    }
+  }
+}
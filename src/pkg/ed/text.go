@@ -0,0 +1,63 @@
+package ed
+
+// A copy of a buffer's text with a gap in it where it was last edited. Runs
+// of edits through the text, like those of x and y, each only move the gap
+// from the last one rather than copying everything after them.
+type text struct {
+  s []rune
+  gap0, gap1 int
+}
+
+func newText(s string) *text {
+  t := &text{s: []rune(s)}
+  t.gap0, t.gap1 = len(t.s), len(t.s)
+  return t
+}
+
+func (t *text) len() int {
+  return len(t.s) - (t.gap1 - t.gap0)
+}
+
+// The rune at q.
+func (t *text) at(q int) rune {
+  if q >= t.gap0 {
+    q += t.gap1 - t.gap0
+  }
+  return t.s[q]
+}
+
+// The runes from q0 up to q1.
+func (t *text) slice(q0, q1 int) string {
+  if q1 <= t.gap0 {
+    return string(t.s[q0:q1])
+  }
+  gap := t.gap1 - t.gap0
+  if q0 >= t.gap0 {
+    return string(t.s[q0 + gap:q1 + gap])
+  }
+  return string(t.s[q0:t.gap0]) + string(t.s[t.gap1:q1 + gap])
+}
+
+// Replaces the runes from q0 up to q1 with r.
+func (t *text) replace(q0, q1 int, r []rune) {
+  // move the gap to q0
+  if q0 < t.gap0 {
+    n := t.gap0 - q0
+    copy(t.s[t.gap1 - n:t.gap1], t.s[q0:t.gap0])
+    t.gap0, t.gap1 = q0, t.gap1 - n
+  } else if q0 > t.gap0 {
+    n := q0 - t.gap0
+    copy(t.s[t.gap0:], t.s[t.gap1:t.gap1 + n])
+    t.gap0, t.gap1 = q0, t.gap1 + n
+  }
+  // swallow what's being replaced, and make room for r
+  t.gap1 += q1 - q0
+  if t.gap1 - t.gap0 < len(r) {
+    s := make([]rune, 2 * len(t.s) + len(r))
+    copy(s, t.s[:t.gap0])
+    after := len(t.s) - t.gap1
+    copy(s[len(s) - after:], t.s[t.gap1:])
+    t.s, t.gap1 = s, len(s) - after
+  }
+  t.gap0 += copy(t.s[t.gap0:], r)
+}
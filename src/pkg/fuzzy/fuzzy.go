@@ -0,0 +1,200 @@
+// Ranks names against a loosely typed pattern, and lets the user pick one.
+package fuzzy
+
+import (
+  "../raster"
+  "sort"
+  "strings"
+  "unicode"
+)
+
+type Match struct {
+  Str string
+  Score int
+  // rune indexes of Str that matched the pattern
+  Positions []int
+}
+
+// Scores s by how well the runes of pattern appear in it, in order. Matches
+// at the start of words, and especially of the file name, count for more, as
+// do runs of consecutive matches. Lower case patterns ignore case.
+func Score(pattern, s string) (m Match, ok bool) {
+  fold := strings.ToLower(pattern) == pattern
+  str := []rune(s)
+  if fold {
+    str = []rune(strings.Map(unicode.ToLower, s))
+  }
+  pat := []rune(pattern)
+  base := len([]rune(s[:strings.LastIndex(s, "/") + 1]))
+  if len(pat) == 0 {
+    return Match{Str: s, Score: -len(str)}, true
+  }
+  // match greedily from each place the pattern could start, keeping the best
+  for start, c := range str {
+    if c != pat[0] {
+      continue
+    }
+    if n, found := score(pat, str, []rune(s), start, base); found && (!ok || n.Score > m.Score) {
+      m, ok = n, true
+    }
+  }
+  m.Str = s
+  return
+}
+
+func score(pat, str, orig []rune, i, base int) (m Match, ok bool) {
+  last := -1
+  for _, p := range pat {
+    for i < len(str) && str[i] != p {
+      i++
+    }
+    if i == len(str) {
+      return m, false
+    }
+    m.Score += 1
+    if i == last + 1 {
+      m.Score += 5
+    }
+    if i == base {
+      m.Score += 10
+    } else if i == 0 || !isWord(orig[i - 1]) || (unicode.IsUpper(orig[i]) && unicode.IsLower(orig[i - 1])) {
+      m.Score += 8
+    }
+    if i >= base {
+      m.Score += 2
+    }
+    m.Positions = append(m.Positions, i)
+    last = i
+    i++
+  }
+  // between equally good matches, prefer shorter names
+  m.Score = m.Score * 1000 - len(str)
+  return m, true
+}
+
+func isWord(c rune) bool {
+  return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Returns the names matching pattern, best first.
+func Rank(pattern string, names []string) []Match {
+  var matches []Match
+  for _, s := range names {
+    if m, ok := Score(pattern, s); ok {
+      matches = append(matches, m)
+    }
+  }
+  sort.SliceStable(matches, func(a, b int) bool {
+    return matches[a].Score > matches[b].Score
+  })
+  return matches
+}
+
+// An overlay that narrows a list of names as a pattern is typed.
+type Finder struct {
+  Title string
+  names []string
+  query []rune
+  matches []Match
+  selected int
+}
+
+func NewFinder(title string, names []string) *Finder {
+  f := &Finder{Title: title, names: names}
+  f.rank()
+  return f
+}
+
+func (f *Finder) rank() {
+  f.matches = Rank(string(f.query), f.names)
+  f.selected = 0
+}
+
+// Handles a key. Returns the chosen name and true when Enter is pressed, or
+// "" and true if the finder was dismissed with ESC.
+func (f *Finder) Key(c rune) (string, bool) {
+  switch (c) {
+  case '\r', '\n':
+    if f.selected < len(f.matches) {
+      return f.matches[f.selected].Str, true
+    }
+  case '\033':
+    return "", true
+  case 0x0E, '\t':
+    if f.selected < len(f.matches) - 1 {
+      f.selected++
+    }
+  case 0x10:
+    if f.selected > 0 {
+      f.selected--
+    }
+  case 8, 0x7F:
+    if len(f.query) > 0 {
+      f.query = f.query[:len(f.query) - 1]
+      f.rank()
+    }
+  case 0x15:
+    f.query = nil
+    f.rank()
+  default:
+    if unicode.IsGraphic(c) {
+      f.query = append(f.query, c)
+      f.rank()
+    }
+  }
+  return "", false
+}
+
+// Draws the finder as a box at row i, column j of ras: the title and pattern
+// on top, then as many of the best matches as fit.
+func (f *Finder) Render(ras *raster.Raster, i, j, rows, cols int) {
+  if rows < 3 || cols < 3 {
+    return
+  }
+  ras.ClearRect(i, j, rows, cols)
+  for k := 0; k < cols; k++ {
+    ras.Put(i, j + k, '-', raster.NORMAL)
+    ras.Put(i + rows - 1, j + k, '-', raster.NORMAL)
+  }
+  for k := 1; k < rows - 1; k++ {
+    ras.Put(i + k, j, '|', raster.NORMAL)
+    ras.Put(i + k, j + cols - 1, '|', raster.NORMAL)
+  }
+  ras.PutString(i, j + 2, 0, clip(" " + f.Title + " ", cols - 4), raster.NORMAL)
+  prompt := clip("> " + string(f.query), cols - 2)
+  ras.PutString(i + 1, j + 1, 0, prompt, raster.NORMAL)
+  ras.Cursor(i + 1, j + 1 + len([]rune(prompt)))
+  // keep the selection in view
+  lines := rows - 3
+  first := 0
+  if f.selected >= lines {
+    first = f.selected - lines + 1
+  }
+  for k := 0; k < lines && first + k < len(f.matches); k++ {
+    m := f.matches[first + k]
+    style := raster.NORMAL
+    if first + k == f.selected {
+      style = raster.HIGHLIGHT
+    }
+    hit := 0
+    for n, c := range []rune(m.Str) {
+      if n >= cols - 2 {
+        break
+      }
+      s := style
+      if hit < len(m.Positions) && m.Positions[hit] == n {
+        s = raster.UNDERLINE
+        hit++
+      }
+      ras.Put(i + 2 + k, j + 1 + n, c, s)
+    }
+  }
+}
+
+// Cuts s down to n runes.
+func clip(s string, n int) string {
+  if r := []rune(s); len(r) > n {
+    return string(r[:n])
+  }
+  return s
+}
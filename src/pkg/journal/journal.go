@@ -0,0 +1,137 @@
+// Copies of buffers with unsaved edits, kept on the local disk, one for each
+// host and path, so that the edits outlive a crash or a dropped connection and
+// can be recovered when the file is next opened.
+//
+// Only one process at a time journals a file: it claims the file first, and
+// the claim lasts until it is released or the process dies.
+package journal
+
+import (
+  "fmt"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+type Journal struct {
+  dir string
+}
+
+// A copy of a buffer, and when it was written.
+type Copy struct {
+  Contents string
+  Time time.Time
+}
+
+// Keeps copies in dir, which is made if it doesn't exist.
+func Open(dir string) (*Journal, error) {
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return nil, err
+  }
+  return &Journal{dir}, nil
+}
+
+// The file the copy of host:p is kept in. Escaping the whole name keeps
+// every file in the one directory, and cleaning p first gives the same file
+// for each way of writing it.
+func (j *Journal) name(host, p string) string {
+  return filepath.Join(j.dir, url.PathEscape(host + ":" + path.Clean(p)))
+}
+
+// Claims host:p for this process. Returns 0 if it has the claim now, or else
+// the process ID of the live process that does. Claims left by processes that
+// have died are taken over.
+func (j *Journal) Claim(host, p string) (owner int, err error) {
+  lock := j.name(host, p) + ".pid"
+  for {
+    f, err := os.OpenFile(lock, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+    if err == nil {
+      _, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+      if cerr := f.Close(); err == nil {
+        err = cerr
+      }
+      return 0, err
+    } else if !os.IsExist(err) {
+      return 0, err
+    }
+    owner, err = ownerOf(lock)
+    if err != nil {
+      return 0, err
+    } else if owner == os.Getpid() {
+      return 0, nil
+    } else if owner > 0 && alive(owner) {
+      return owner, nil
+    }
+    if err := os.Remove(lock); err != nil && !os.IsNotExist(err) {
+      return 0, err
+    }
+  }
+}
+
+// The process ID in a lock file, or 0 if it has none.
+func ownerOf(lock string) (int, error) {
+  data, err := os.ReadFile(lock)
+  if os.IsNotExist(err) {
+    return 0, nil
+  } else if err != nil {
+    return 0, err
+  }
+  pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+  return pid, nil
+}
+
+func alive(pid int) bool {
+  err := syscall.Kill(pid, 0)
+  return err == nil || err == syscall.EPERM
+}
+
+// Gives up this process's claim on host:p, if it has it.
+func (j *Journal) Release(host, p string) error {
+  lock := j.name(host, p) + ".pid"
+  if owner, err := ownerOf(lock); err != nil || owner != os.Getpid() {
+    return err
+  }
+  if err := os.Remove(lock); err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
+
+// Replaces the copy of host:p. The new copy is written alongside the old one
+// first, so that a crash while writing leaves the old one whole.
+func (j *Journal) Write(host, p, contents string) error {
+  name := j.name(host, p)
+  if err := os.WriteFile(name + ".new", []byte(contents), 0600); err != nil {
+    return err
+  }
+  return os.Rename(name + ".new", name)
+}
+
+// The copy of host:p, or nil if there isn't one.
+func (j *Journal) Read(host, p string) (*Copy, error) {
+  name := j.name(host, p)
+  info, err := os.Stat(name)
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+  contents, err := os.ReadFile(name)
+  if err != nil {
+    return nil, err
+  }
+  return &Copy{string(contents), info.ModTime()}, nil
+}
+
+// Throws away the copy of host:p, if there is one.
+func (j *Journal) Remove(host, p string) error {
+  if err := os.Remove(j.name(host, p)); err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
@@ -0,0 +1,110 @@
+package journal
+
+import (
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strconv"
+  "testing"
+)
+
+func TestCopies(t *testing.T) {
+  j, err := Open(filepath.Join(t.TempDir(), "ged", "journal"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if c, err := j.Read("h", "/a/b c"); c != nil || err != nil {
+    t.Fatalf("read %+v, %v before writing anything", c, err)
+  }
+  if err := j.Write("h", "/a/b c", "one\n"); err != nil {
+    t.Fatal(err)
+  }
+  if err := j.Write("h", "/a/b c", "two\n"); err != nil {
+    t.Fatal(err)
+  }
+  c, err := j.Read("h", "/a/b c")
+  if err != nil || c == nil || c.Contents != "two\n" || c.Time.IsZero() {
+    t.Fatalf("read %+v, %v", c, err)
+  }
+  // the same file however its path is written, but not on another host
+  if c, _ := j.Read("h", "/a/./x/../b c"); c == nil {
+    t.Errorf("no copy under an uncleaned path")
+  }
+  if c, _ := j.Read("g", "/a/b c"); c != nil {
+    t.Errorf("copy found on another host")
+  }
+  if err := j.Remove("h", "/a/b c"); err != nil {
+    t.Fatal(err)
+  }
+  if err := j.Remove("h", "/a/b c"); err != nil {
+    t.Errorf("removing twice: %v", err)
+  }
+  if c, _ := j.Read("h", "/a/b c"); c != nil {
+    t.Errorf("copy still there after Remove")
+  }
+}
+
+func TestClaims(t *testing.T) {
+  j, err := Open(t.TempDir())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if owner, err := j.Claim("h", "x"); owner != 0 || err != nil {
+    t.Fatalf("first claim gave %d, %v", owner, err)
+  }
+  if owner, err := j.Claim("h", "./x"); owner != 0 || err != nil {
+    t.Errorf("claiming again gave %d, %v", owner, err)
+  }
+  if err := j.Release("h", "x"); err != nil {
+    t.Fatal(err)
+  }
+
+  // a live process keeps its claim, and Release leaves it alone
+  lock := j.name("h", "y") + ".pid"
+  parent := os.Getppid()
+  os.WriteFile(lock, []byte(strconv.Itoa(parent) + "\n"), 0600)
+  if owner, err := j.Claim("h", "y"); owner != parent || err != nil {
+    t.Errorf("claim held by %d gave %d, %v", parent, owner, err)
+  }
+  j.Release("h", "y")
+  if _, err := os.Stat(lock); err != nil {
+    t.Errorf("released another process's claim: %v", err)
+  }
+
+  // one that has died loses it
+  cmd := exec.Command("true")
+  if err := cmd.Run(); err != nil {
+    t.Skip(err)
+  }
+  os.WriteFile(lock, []byte(strconv.Itoa(cmd.Process.Pid) + "\n"), 0600)
+  if owner, err := j.Claim("h", "y"); owner != 0 || err != nil {
+    t.Errorf("claim held by a dead process gave %d, %v", owner, err)
+  }
+}
+
+// A session that dies leaves its copy and its claim, and the next session
+// to open the file takes over both.
+func TestRecovery(t *testing.T) {
+  dir := t.TempDir()
+  j, _ := Open(dir)
+  cmd := exec.Command("true")
+  if err := cmd.Run(); err != nil {
+    t.Skip(err)
+  }
+  j.Write("h", "/f", "edited\n")
+  os.WriteFile(j.name("h", "/f") + ".pid", []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+
+  next, _ := Open(dir)
+  if owner, err := next.Claim("h", "/f"); owner != 0 || err != nil {
+    t.Fatalf("claim gave %d, %v", owner, err)
+  }
+  c, err := next.Read("h", "/f")
+  if err != nil || c == nil || c.Contents != "edited\n" {
+    t.Fatalf("recovered %+v, %v", c, err)
+  }
+  next.Remove("h", "/f")
+  next.Release("h", "/f")
+  if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+    t.Errorf("left %d files behind", len(entries))
+  }
+}
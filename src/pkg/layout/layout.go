@@ -0,0 +1,242 @@
+// Tiles a raster with windows, split horizontally and vertically.
+package layout
+
+import (
+  "../buffer"
+  "../raster"
+)
+
+// Either a window, or a row or column of smaller tiles.
+type tile struct {
+  win *buffer.Window
+  // children sit side by side when vertical, stacked otherwise
+  vertical bool
+  children []*tile
+  parent *tile
+  i, j, rows, cols int
+}
+
+// The fewest rows a tile can have: one of text and its status line.
+const MIN_ROWS = 2
+
+type Layout struct {
+  root, focus *tile
+  rows, cols int
+  // Text for each window's status line. Defaults to the window's name.
+  Status func(w *buffer.Window) string
+}
+
+func New(w *buffer.Window) *Layout {
+  t := &tile{win: w}
+  return &Layout{root: t, focus: t}
+}
+
+func (l *Layout) Focus() *buffer.Window {
+  return l.focus.win
+}
+
+// Focuses the tile showing w, if there is one.
+func (l *Layout) Select(w *buffer.Window) {
+  l.root.leaves(func(t *tile) {
+    if t.win == w {
+      l.focus = t
+    }
+  })
+}
+
+// Shows w in place of the focused window.
+func (l *Layout) Show(w *buffer.Window) {
+  l.focus.win = w
+}
+
+// All windows on screen, top left first.
+func (l *Layout) Windows() (ws []*buffer.Window) {
+  l.root.leaves(func(t *tile) {
+    ws = append(ws, t.win)
+  })
+  return
+}
+
+func (l *Layout) Visible(w *buffer.Window) bool {
+  for _, v := range l.Windows() {
+    if v == w {
+      return true
+    }
+  }
+  return false
+}
+
+func (t *tile) leaves(f func(t *tile)) {
+  if t.win != nil {
+    f(t)
+    return
+  }
+  for _, c := range t.children {
+    c.leaves(f)
+  }
+}
+
+// Splits the focused window in two, showing w in the new half, which gets the
+// focus. Returns false if the window is too small to split.
+func (l *Layout) Split(w *buffer.Window, vertical bool) bool {
+  t := l.focus
+  if l.rows > 0 && (vertical && t.cols < 3 || !vertical && t.rows < 2 * MIN_ROWS) {
+    return false
+  }
+  n := &tile{win: w}
+  p := t.parent
+  if p == nil || p.vertical != vertical {
+    // turn the focused tile into a row or column holding the old window
+    old := &tile{win: t.win, parent: t}
+    t.win = nil
+    t.vertical = vertical
+    t.children = []*tile{old}
+    p = t
+    t = old
+  }
+  n.parent = p
+  for k, c := range p.children {
+    if c == t {
+      p.children = append(p.children[:k + 1], append([]*tile{n}, p.children[k + 1:]...)...)
+      break
+    }
+  }
+  l.focus = n
+  return true
+}
+
+// Closes the focused window, returning false if it is the only one.
+func (l *Layout) Close() bool {
+  t := l.focus
+  p := t.parent
+  if p == nil {
+    return false
+  }
+  k := 0
+  for k = range p.children {
+    if p.children[k] == t {
+      break
+    }
+  }
+  p.children = append(p.children[:k], p.children[k + 1:]...)
+  if len(p.children) == 1 {
+    // a row or column of one is just its child
+    only := p.children[0]
+    p.win, p.vertical, p.children = only.win, only.vertical, only.children
+    for _, c := range p.children {
+      c.parent = p
+    }
+  }
+  if k > 0 && p.win == nil {
+    k--
+  }
+  if p.win != nil {
+    l.focus = p
+  } else {
+    l.focus = p.children[k].first()
+  }
+  return true
+}
+
+func (t *tile) first() *tile {
+  for t.win == nil {
+    t = t.children[0]
+  }
+  return t
+}
+
+// Moves the focus to the next window, wrapping around.
+func (l *Layout) Next() {
+  var all []*tile
+  l.root.leaves(func(t *tile) {
+    all = append(all, t)
+  })
+  for k, t := range all {
+    if t == l.focus {
+      l.focus = all[(k + 1) % len(all)]
+      return
+    }
+  }
+}
+
+// Moves the focus to the neighbouring window in direction h, j, k or l.
+func (l *Layout) Move(dir rune) {
+  t := l.focus
+  i, j := t.i, t.j
+  switch (dir) {
+  case 'h': j -= 2
+  case 'l': j += t.cols + 1
+  case 'k': i -= 1
+  case 'j': i += t.rows
+  }
+  l.root.leaves(func(c *tile) {
+    if i >= c.i && i < c.i + c.rows && j >= c.j && j < c.j + c.cols {
+      l.focus = c
+    }
+  })
+}
+
+// Lays out and draws every window into the given rows and columns of ras.
+// Each window gets a status line below it and a border to its right.
+func (l *Layout) Render(ras *raster.Raster, rows, cols int) {
+  l.rows, l.cols = rows, cols
+  l.root.place(0, 0, rows, cols)
+  l.root.leaves(func(t *tile) {
+    if t != l.focus {
+      l.draw(ras, t)
+    }
+  })
+  // the focused window goes last so that it owns the cursor
+  l.draw(ras, l.focus)
+}
+
+func (t *tile) place(i, j, rows, cols int) {
+  t.i, t.j, t.rows, t.cols = i, j, rows, cols
+  n := len(t.children)
+  for k, c := range t.children {
+    if t.vertical {
+      // one column of each width goes to the border
+      w := (cols + 1) / n - 1
+      left := j + (w + 1) * k
+      if k == n - 1 {
+        w = j + cols - left
+      }
+      c.place(i, left, rows, w)
+    } else {
+      h := rows / n
+      if k == n - 1 {
+        h = rows - h * k
+      }
+      c.place(i + rows / n * k, j, h, cols)
+    }
+  }
+}
+
+func (l *Layout) draw(ras *raster.Raster, t *tile) {
+  // a screen shrunk after splitting can leave no room for some tiles
+  if t.rows < MIN_ROWS || t.cols < 1 {
+    return
+  }
+  t.win.Resize(t.i, t.j, t.rows - 1, t.cols)
+  t.win.Render(ras)
+  status := t.win.Name
+  if l.Status != nil {
+    status = l.Status(t.win)
+  }
+  style := raster.UNDERLINE
+  if t == l.focus {
+    style = raster.HIGHLIGHT
+  }
+  for k := 0; k < t.cols; k++ {
+    ras.Put(t.i + t.rows - 1, t.j + k, ' ', style)
+  }
+  if r := []rune(status); len(r) > t.cols {
+    status = string(r[:t.cols])
+  }
+  ras.PutString(t.i + t.rows - 1, t.j, 0, status, style)
+  if t.j + t.cols < l.cols {
+    for k := 0; k < t.rows; k++ {
+      ras.Put(t.i + k, t.j + t.cols, '|', raster.NORMAL)
+    }
+  }
+}
@@ -0,0 +1,483 @@
+// A client for language servers, speaking LSP's JSON-RPC over the server's
+// standard input and output.
+package lsp
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/url"
+  "path"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// How long to wait for an answer before giving up on a request.
+const TIMEOUT = 10 * time.Second
+
+// How bad a Diagnostic is.
+const (
+  ERROR = 1
+  WARNING = 2
+  INFORMATION = 3
+  HINT = 4
+)
+
+// Lines and characters count from 0, characters in UTF-16 code units.
+type Position struct {
+  Line int `json:"line"`
+  Character int `json:"character"`
+}
+
+type Range struct {
+  Start Position `json:"start"`
+  End Position `json:"end"`
+}
+
+type Location struct {
+  URI string `json:"uri"`
+  Range Range `json:"range"`
+}
+
+type Diagnostic struct {
+  Range Range `json:"range"`
+  Severity int `json:"severity"`
+  Message string `json:"message"`
+}
+
+type TextEdit struct {
+  Range Range `json:"range"`
+  NewText string `json:"newText"`
+}
+
+// A completion. If TextEdit is set, it says what to replace with what, and
+// otherwise InsertText, or failing that Label, goes in place of the word
+// before the cursor.
+type Item struct {
+  Label string `json:"label"`
+  Detail string `json:"detail"`
+  InsertText string `json:"insertText"`
+  TextEdit *TextEdit `json:"textEdit"`
+}
+
+type object map[string]interface{}
+
+type message struct {
+  JSONRPC string `json:"jsonrpc"`
+  ID json.RawMessage `json:"id,omitempty"`
+  Method string `json:"method,omitempty"`
+  Params json.RawMessage `json:"params,omitempty"`
+  Result json.RawMessage `json:"result,omitempty"`
+  Error *struct {
+    Code int `json:"code"`
+    Message string `json:"message"`
+  } `json:"error,omitempty"`
+}
+
+type Client struct {
+  in io.WriteCloser
+  // held while writing a message to in
+  writing sync.Mutex
+  // guards everything below
+  lock sync.Mutex
+  next int
+  pending map[int]chan *message
+  versions map[string]int
+  diagnostics map[string][]Diagnostic
+  // set once the server has gone away
+  err error
+  changed func(uri string)
+  // how long to wait for an answer, TIMEOUT unless a test says otherwise
+  timeout time.Duration
+}
+
+// Talks to a server through its standard input and output. changed is
+// called, from the goroutine reading out, whenever a file's diagnostics
+// change.
+func New(in io.WriteCloser, out io.Reader, changed func(uri string)) *Client {
+  c := &Client{
+    in: in,
+    pending: map[int]chan *message{},
+    versions: map[string]int{},
+    diagnostics: map[string][]Diagnostic{},
+    changed: changed,
+    timeout: TIMEOUT,
+  }
+  go c.read(out)
+  return c
+}
+
+// The URI of a path on the server's machine.
+func URI(p string) string {
+  return "file://" + (&url.URL{Path: p}).EscapedPath()
+}
+
+// The path a file URI names, or "" if it isn't one.
+func Path(uri string) string {
+  u, err := url.Parse(uri)
+  if err != nil || u.Scheme != "file" {
+    return ""
+  }
+  return u.Path
+}
+
+// How many UTF-16 code units the first col runes of line take.
+func UTF16(line string, col int) (units int) {
+  for _, c := range line {
+    if col == 0 {
+      break
+    }
+    col--
+    units += width(c)
+  }
+  return
+}
+
+// How many runes of line the first units UTF-16 code units cover.
+func Column(line string, units int) (col int) {
+  for _, c := range line {
+    if units < width(c) {
+      break
+    }
+    units -= width(c)
+    col++
+  }
+  return
+}
+
+func width(c rune) int {
+  if c >= 0x10000 {
+    return 2
+  }
+  return 1
+}
+
+// Reads messages from the server until it goes away, answering its requests
+// and handing results to whoever is waiting for them.
+func (c *Client) read(out io.Reader) {
+  r := bufio.NewReader(out)
+  for {
+    m, err := readMessage(r)
+    if err == io.EOF {
+      err = fmt.Errorf("language server exited")
+    }
+    if err != nil {
+      c.lock.Lock()
+      c.err = err
+      for id, ch := range c.pending {
+        close(ch)
+        delete(c.pending, id)
+      }
+      c.lock.Unlock()
+      return
+    }
+    if m.Method != "" && len(m.ID) > 0 {
+      c.answer(m)
+    } else if m.Method != "" {
+      c.handle(m)
+    } else if id, err := strconv.Atoi(string(m.ID)); err == nil {
+      c.lock.Lock()
+      if ch, ok := c.pending[id]; ok {
+        ch <- m
+        delete(c.pending, id)
+      }
+      c.lock.Unlock()
+    }
+  }
+}
+
+// Reads a Content-Length header, any others, and the JSON after them.
+func readMessage(r *bufio.Reader) (*message, error) {
+  length := -1
+  for {
+    line, err := r.ReadString('\n')
+    if err != nil {
+      return nil, err
+    }
+    line = strings.TrimSpace(line)
+    if line == "" {
+      break
+    }
+    if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+      if length, err = strconv.Atoi(strings.TrimSpace(line[i + 1:])); err != nil {
+        return nil, fmt.Errorf("bad header: %s", line)
+      }
+    }
+  }
+  if length < 0 {
+    return nil, fmt.Errorf("message without a Content-Length")
+  }
+  data := make([]byte, length)
+  if _, err := io.ReadFull(r, data); err != nil {
+    return nil, err
+  }
+  m := &message{}
+  return m, json.Unmarshal(data, m)
+}
+
+// Answers a request from the server. ged has no settings to give it and
+// nothing to say about anything else, but servers may wait for an answer.
+func (c *Client) answer(m *message) {
+  result := json.RawMessage("null")
+  if m.Method == "workspace/configuration" {
+    var params struct {
+      Items []json.RawMessage `json:"items"`
+    }
+    json.Unmarshal(m.Params, &params)
+    result, _ = json.Marshal(make([]interface{}, len(params.Items)))
+  }
+  c.send(&message{ID: m.ID, Result: result}, nil)
+}
+
+// Handles a notification from the server. Only diagnostics are kept.
+func (c *Client) handle(m *message) {
+  if m.Method != "textDocument/publishDiagnostics" {
+    return
+  }
+  var params struct {
+    URI string `json:"uri"`
+    Diagnostics []Diagnostic `json:"diagnostics"`
+  }
+  if json.Unmarshal(m.Params, &params) != nil {
+    return
+  }
+  c.lock.Lock()
+  c.diagnostics[params.URI] = params.Diagnostics
+  c.lock.Unlock()
+  if c.changed != nil {
+    c.changed(params.URI)
+  }
+}
+
+// The latest diagnostics for uri.
+func (c *Client) Diagnostics(uri string) []Diagnostic {
+  c.lock.Lock()
+  defer c.lock.Unlock()
+  return append([]Diagnostic(nil), c.diagnostics[uri]...)
+}
+
+func (c *Client) send(m *message, params interface{}) error {
+  m.JSONRPC = "2.0"
+  if params != nil {
+    var err error
+    if m.Params, err = json.Marshal(params); err != nil {
+      return err
+    }
+  }
+  data, err := json.Marshal(m)
+  if err != nil {
+    return err
+  }
+  c.writing.Lock()
+  defer c.writing.Unlock()
+  _, err = fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(data), data)
+  return err
+}
+
+func (c *Client) notify(method string, params interface{}) error {
+  c.lock.Lock()
+  err := c.err
+  c.lock.Unlock()
+  if err != nil {
+    return err
+  }
+  return c.send(&message{Method: method}, params)
+}
+
+// Sends a request and waits for its result, which is decoded into result.
+// Gives up after TIMEOUT, telling the server not to bother.
+func (c *Client) call(method string, params, result interface{}) error {
+  c.lock.Lock()
+  if c.err != nil {
+    defer c.lock.Unlock()
+    return c.err
+  }
+  c.next++
+  id := c.next
+  ch := make(chan *message, 1)
+  c.pending[id] = ch
+  c.lock.Unlock()
+  if err := c.send(&message{ID: json.RawMessage(strconv.Itoa(id)), Method: method}, params); err != nil {
+    c.forget(id)
+    return err
+  }
+  select {
+  case m, ok := <-ch:
+    if !ok {
+      c.lock.Lock()
+      defer c.lock.Unlock()
+      return c.err
+    }
+    if m.Error != nil {
+      return fmt.Errorf("%s: %s", method, m.Error.Message)
+    }
+    if result == nil || len(m.Result) == 0 || string(m.Result) == "null" {
+      return nil
+    }
+    return json.Unmarshal(m.Result, result)
+  case <-time.After(c.timeout):
+    c.forget(id)
+    c.notify("$/cancelRequest", object{"id": id})
+    return fmt.Errorf("%s: timed out", method)
+  }
+}
+
+func (c *Client) forget(id int) {
+  c.lock.Lock()
+  delete(c.pending, id)
+  c.lock.Unlock()
+}
+
+// Starts a session for the project at root, a path on the server's machine.
+func (c *Client) Initialize(root string) error {
+  err := c.call("initialize", object{
+    "processId": nil,
+    "rootUri": URI(root),
+    "workspaceFolders": []object{{"uri": URI(root), "name": path.Base(root)}},
+    "capabilities": object{
+      "workspace": object{"configuration": true},
+      "textDocument": object{
+        "synchronization": object{},
+        "hover": object{"contentFormat": []string{"plaintext"}},
+        "completion": object{"completionItem": object{"snippetSupport": false}},
+        "definition": object{},
+        "publishDiagnostics": object{},
+      },
+    },
+  }, nil)
+  if err != nil {
+    return err
+  }
+  return c.notify("initialized", object{})
+}
+
+// Tells the server a file is being edited, and what is in it.
+func (c *Client) Open(uri, language, text string) error {
+  c.lock.Lock()
+  c.versions[uri] = 1
+  c.lock.Unlock()
+  return c.notify("textDocument/didOpen", object{
+    "textDocument": object{"uri": uri, "languageId": language, "version": 1, "text": text},
+  })
+}
+
+// Tells the server what is in a file now. The whole of it is sent, which
+// every server understands.
+func (c *Client) Change(uri, text string) error {
+  c.lock.Lock()
+  c.versions[uri]++
+  version := c.versions[uri]
+  c.lock.Unlock()
+  return c.notify("textDocument/didChange", object{
+    "textDocument": object{"uri": uri, "version": version},
+    "contentChanges": []object{{"text": text}},
+  })
+}
+
+func (c *Client) Close(uri string) error {
+  c.lock.Lock()
+  delete(c.versions, uri)
+  delete(c.diagnostics, uri)
+  c.lock.Unlock()
+  return c.notify("textDocument/didClose", object{"textDocument": object{"uri": uri}})
+}
+
+func at(uri string, pos Position) object {
+  return object{"textDocument": object{"uri": uri}, "position": pos}
+}
+
+// Describes whatever is at pos, as plain text.
+func (c *Client) Hover(uri string, pos Position) (string, error) {
+  var result struct {
+    Contents json.RawMessage `json:"contents"`
+  }
+  if err := c.call("textDocument/hover", at(uri, pos), &result); err != nil {
+    return "", err
+  }
+  return strings.TrimSpace(markup(result.Contents)), nil
+}
+
+// Hover contents are a string, an object with the string in its value, or a
+// list of either.
+func markup(raw json.RawMessage) string {
+  var s string
+  if json.Unmarshal(raw, &s) == nil {
+    return s
+  }
+  var list []json.RawMessage
+  if json.Unmarshal(raw, &list) == nil {
+    var parts []string
+    for _, part := range list {
+      parts = append(parts, markup(part))
+    }
+    return strings.Join(parts, "\n\n")
+  }
+  var m struct {
+    Value string `json:"value"`
+  }
+  json.Unmarshal(raw, &m)
+  return m.Value
+}
+
+// Finds where whatever is at pos is defined.
+func (c *Client) Definition(uri string, pos Position) ([]Location, error) {
+  var raw json.RawMessage
+  if err := c.call("textDocument/definition", at(uri, pos), &raw); err != nil || len(raw) == 0 {
+    return nil, err
+  }
+  // a Location, a list of them, or a list of LocationLinks
+  type link struct {
+    Location
+    TargetURI string `json:"targetUri"`
+    TargetSelectionRange Range `json:"targetSelectionRange"`
+  }
+  var links []link
+  if raw[0] == '[' {
+    if err := json.Unmarshal(raw, &links); err != nil {
+      return nil, err
+    }
+  } else {
+    var l link
+    if err := json.Unmarshal(raw, &l); err != nil {
+      return nil, err
+    }
+    links = append(links, l)
+  }
+  var locations []Location
+  for _, l := range links {
+    if l.TargetURI != "" {
+      l.URI, l.Range = l.TargetURI, l.TargetSelectionRange
+    }
+    locations = append(locations, l.Location)
+  }
+  return locations, nil
+}
+
+// Lists what could be typed at pos.
+func (c *Client) Completion(uri string, pos Position) ([]Item, error) {
+  var raw json.RawMessage
+  if err := c.call("textDocument/completion", at(uri, pos), &raw); err != nil || len(raw) == 0 {
+    return nil, err
+  }
+  // a list of items, or an object holding one
+  var items []Item
+  if raw[0] == '[' {
+    return items, json.Unmarshal(raw, &items)
+  }
+  var list struct {
+    Items []Item `json:"items"`
+  }
+  err := json.Unmarshal(raw, &list)
+  return list.Items, err
+}
+
+// Asks the server to finish up, then to exit.
+func (c *Client) Shutdown() error {
+  err := c.call("shutdown", nil, nil)
+  c.notify("exit", nil)
+  c.in.Close()
+  return err
+}
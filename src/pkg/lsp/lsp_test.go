@@ -0,0 +1,290 @@
+package lsp
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+// A language server at the other end of a pair of pipes. Each message the
+// client sends is handed to answer, which returns the raw JSON result to
+// reply with, or "" to leave it unanswered. Everything the client sends,
+// answers included, also goes to received.
+type fake struct {
+  t *testing.T
+  out io.Writer
+  answer func(m *message) string
+  received chan *message
+}
+
+func start(t *testing.T, answer func(m *message) string, changed func(uri string)) (*Client, *fake) {
+  inR, inW := io.Pipe()
+  outR, outW := io.Pipe()
+  f := &fake{t: t, out: outW, answer: answer, received: make(chan *message, 100)}
+  go func() {
+    r := bufio.NewReader(inR)
+    for {
+      m, err := readMessage(r)
+      if err != nil {
+        outW.Close()
+        return
+      }
+      f.received <- m
+      if m.Method != "" && len(m.ID) > 0 && f.answer != nil {
+        if result := f.answer(m); result != "" {
+          f.send(&message{ID: m.ID, Result: json.RawMessage(result)})
+        }
+      }
+    }
+  }()
+  c := New(inW, outR, changed)
+  t.Cleanup(func() {
+    inW.Close()
+  })
+  return c, f
+}
+
+func (f *fake) send(m *message) {
+  m.JSONRPC = "2.0"
+  data, err := json.Marshal(m)
+  if err != nil {
+    f.t.Fatal(err)
+  }
+  fmt.Fprintf(f.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// The next message the client sends with method, or an answer if method is "".
+func (f *fake) expect(method string) *message {
+  for {
+    select {
+    case m := <-f.received:
+      if m.Method == method {
+        return m
+      }
+    case <-time.After(time.Second):
+      f.t.Fatalf("no %q from the client", method)
+      return nil
+    }
+  }
+}
+
+type nopCloser struct {
+  io.Writer
+}
+
+func (nopCloser) Close() error {
+  return nil
+}
+
+func TestFraming(t *testing.T) {
+  var out bytes.Buffer
+  c := &Client{in: nopCloser{&out}}
+  if err := c.notify("initialized", object{}); err != nil {
+    t.Fatal(err)
+  }
+  body := `{"jsonrpc":"2.0","method":"initialized","params":{}}`
+  if want := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body); out.String() != want {
+    t.Errorf("sent %q, want %q", out.String(), want)
+  }
+
+  // other headers are skipped, and the length's name is any case
+  in := "content-length: 2\r\nContent-Type: application/vscode-jsonrpc\r\n\r\n{}" +
+    "Content-Length: 15\r\n\r\n{\"method\":\"é\"}xx"
+  r := bufio.NewReader(strings.NewReader(in))
+  if _, err := readMessage(r); err != nil {
+    t.Fatal(err)
+  }
+  m, err := readMessage(r)
+  if err != nil || m.Method != "é" {
+    t.Errorf("second message gave %+v, %v", m, err)
+  }
+  r = bufio.NewReader(strings.NewReader("Content-Type: x\r\n\r\n{}"))
+  if _, err := readMessage(r); err == nil {
+    t.Errorf("no error without a Content-Length")
+  }
+}
+
+func TestAnswersMatchRequests(t *testing.T) {
+  requests := make(chan *message, 2)
+  c, f := start(t, func(m *message) string {
+    requests <- m
+    return ""
+  }, nil)
+  type hover struct {
+    text string
+    err error
+  }
+  results := make(chan hover, 2)
+  for _, line := range []int{1, 2} {
+    go func(line int) {
+      text, err := c.Hover("file:///x", Position{line, 0})
+      results <- hover{text, err}
+    }(line)
+  }
+  // answered in the opposite order to the one they were asked in
+  a, b := <-requests, <-requests
+  for _, m := range []*message{b, a} {
+    var params struct {
+      Position Position `json:"position"`
+    }
+    json.Unmarshal(m.Params, &params)
+    f.send(&message{ID: m.ID, Result: json.RawMessage(fmt.Sprintf(`{"contents":"line %d"}`, params.Position.Line))})
+  }
+  got := map[string]bool{}
+  for i := 0; i < 2; i++ {
+    r := <-results
+    if r.err != nil {
+      t.Fatal(r.err)
+    }
+    got[r.text] = true
+  }
+  if !got["line 1"] || !got["line 2"] {
+    t.Errorf("got %v, want an answer for each line", got)
+  }
+}
+
+func TestTimeout(t *testing.T) {
+  c, f := start(t, func(m *message) string {
+    return ""
+  }, nil)
+  c.timeout = 10 * time.Millisecond
+  _, err := c.Hover("file:///x", Position{})
+  if err == nil || !strings.Contains(err.Error(), "timed out") {
+    t.Fatalf("got %v, want a timeout", err)
+  }
+  asked := f.expect("textDocument/hover")
+  cancel := f.expect("$/cancelRequest")
+  var params struct {
+    ID json.RawMessage `json:"id"`
+  }
+  json.Unmarshal(cancel.Params, &params)
+  if string(params.ID) != string(asked.ID) {
+    t.Errorf("cancelled %s, want %s", params.ID, asked.ID)
+  }
+  // an answer turning up late is dropped, and later requests still work
+  f.send(&message{ID: asked.ID, Result: json.RawMessage(`{"contents":"late"}`)})
+  f.answer = func(m *message) string {
+    return `{"contents":"on time"}`
+  }
+  c.timeout = time.Second
+  if text, err := c.Hover("file:///x", Position{}); text != "on time" || err != nil {
+    t.Errorf("after a timeout, got %q, %v", text, err)
+  }
+}
+
+func TestResults(t *testing.T) {
+  var result string
+  c, _ := start(t, func(m *message) string {
+    return result
+  }, nil)
+  loc := `{"uri":"file:///a%20b.go","range":{"start":{"line":3,"character":1},"end":{"line":3,"character":4}}}`
+  want := Location{"file:///a%20b.go", Range{Position{3, 1}, Position{3, 4}}}
+  for _, test := range []struct {
+    result string
+    call func() (interface{}, error)
+    want interface{}
+  }{
+    {`{"contents":"plain"}`, hoverAt(c), "plain"},
+    {`{"contents":{"kind":"markdown","value":"marked up"}}`, hoverAt(c), "marked up"},
+    {`{"contents":["one",{"language":"go","value":"two"}]}`, hoverAt(c), "one\n\ntwo"},
+    {`null`, hoverAt(c), ""},
+    {loc, definitionAt(c), []Location{want}},
+    {"[" + loc + "," + loc + "]", definitionAt(c), []Location{want, want}},
+    {`[{"targetUri":"file:///a%20b.go","targetRange":{},"targetSelectionRange":{"start":{"line":3,"character":1},"end":{"line":3,"character":4}}}]`,
+      definitionAt(c), []Location{want}},
+    {`null`, definitionAt(c), []Location(nil)},
+    {`[{"label":"Println","insertText":"Println("}]`, completionAt(c), []Item{{Label: "Println", InsertText: "Println("}}},
+    {`{"isIncomplete":false,"items":[{"label":"x","detail":"int"}]}`, completionAt(c), []Item{{Label: "x", Detail: "int"}}},
+  } {
+    result = test.result
+    got, err := test.call()
+    if err != nil {
+      t.Errorf("%s: %v", test.result, err)
+    } else if !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s gave %#v, want %#v", test.result, got, test.want)
+    }
+  }
+}
+
+func hoverAt(c *Client) func() (interface{}, error) {
+  return func() (interface{}, error) {
+    return c.Hover("file:///x", Position{})
+  }
+}
+
+func definitionAt(c *Client) func() (interface{}, error) {
+  return func() (interface{}, error) {
+    return c.Definition("file:///x", Position{})
+  }
+}
+
+func completionAt(c *Client) func() (interface{}, error) {
+  return func() (interface{}, error) {
+    return c.Completion("file:///x", Position{})
+  }
+}
+
+func TestServerRequests(t *testing.T) {
+  changed := make(chan string, 1)
+  c, f := start(t, nil, func(uri string) {
+    changed <- uri
+  })
+  f.send(&message{ID: json.RawMessage(`"s1"`), Method: "workspace/configuration",
+    Params: json.RawMessage(`{"items":[{"section":"gopls"},{}]}`)})
+  if m := f.expect(""); string(m.ID) != `"s1"` || string(m.Result) != `[null,null]` {
+    t.Errorf("configuration answered with %s for %s", m.Result, m.ID)
+  }
+  f.send(&message{ID: json.RawMessage(`7`), Method: "window/workDoneProgress/create", Params: json.RawMessage(`{}`)})
+  if m := f.expect(""); string(m.ID) != `7` || string(m.Result) != `null` {
+    t.Errorf("other request answered with %s for %s", m.Result, m.ID)
+  }
+  f.send(&message{Method: "textDocument/publishDiagnostics",
+    Params: json.RawMessage(`{"uri":"file:///x","diagnostics":[{"range":{},"severity":2,"message":"unused"}]}`)})
+  select {
+  case uri := <-changed:
+    if uri != "file:///x" {
+      t.Errorf("diagnostics changed for %s", uri)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("diagnostics never arrived")
+  }
+  if d := c.Diagnostics("file:///x"); len(d) != 1 || d[0].Severity != WARNING || d[0].Message != "unused" {
+    t.Errorf("got diagnostics %+v", d)
+  }
+}
+
+func TestServerExits(t *testing.T) {
+  c, f := start(t, func(m *message) string {
+    if m.Method == "initialize" {
+      return "{}"
+    }
+    return ""
+  }, nil)
+  if err := c.Initialize("/src/x"); err != nil {
+    t.Fatal(err)
+  }
+  f.expect("initialized")
+  f.out.(io.Closer).Close()
+  if _, err := c.Hover("file:///x", Position{}); err == nil {
+    t.Errorf("no error once the server has gone")
+  }
+}
+
+func TestColumns(t *testing.T) {
+  line := "a😀b"
+  if n := UTF16(line, 2); n != 3 {
+    t.Errorf("UTF16 gave %d, want 3", n)
+  }
+  if n := Column(line, 3); n != 2 {
+    t.Errorf("Column gave %d, want 2", n)
+  }
+  if p := Path(URI("/a b/c.go")); p != "/a b/c.go" {
+    t.Errorf("path came back as %q", p)
+  }
+}
@@ -0,0 +1,109 @@
+// Decides what to do with a piece of text, acme style.
+package plumb
+
+import (
+  "bufio"
+  "fmt"
+  "os"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+// What to do with matching text.
+type Action string
+
+const (
+  // Open the file at Match.Path, at Match.Line and Match.Col if given.
+  OPEN Action = "open"
+  // Hand the text to the user, who has a browser and we don't.
+  URL Action = "url"
+  // Run the text as a remote command.
+  RUN Action = "run"
+)
+
+// Text matching Pattern gets Action. For OPEN, the pattern's first three groups
+// are the path, line and column.
+type Rule struct {
+  Action Action
+  Pattern *regexp.Regexp
+}
+
+type Match struct {
+  Action Action
+  Text string
+  Path string
+  Line, Col int
+}
+
+// Used when there is no rules file.
+var Default = []Rule{
+  {OPEN, regexp.MustCompile(`^([^:\s]+):(\d+)(?::(\d+))?:?$`)},
+  {URL, regexp.MustCompile(`^(https?|ftp)://\S+$`)},
+  {OPEN, regexp.MustCompile(`^([^:\s]*/[^:\s]*)$`)},
+  {RUN, regexp.MustCompile(`.`)},
+}
+
+// Reads rules from a file of lines like
+//
+//   open ^([^:]+):(\d+)$
+//
+// Blank lines and lines starting with # are skipped. A missing file gives the
+// Default rules.
+func Load(path string) ([]Rule, error) {
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return Default, nil
+  } else if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  var rules []Rule
+  scanner := bufio.NewScanner(f)
+  for n := 1; scanner.Scan(); n++ {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    fields := strings.SplitN(line, " ", 2)
+    if len(fields) != 2 {
+      return nil, fmt.Errorf("%s:%d: expected an action and a pattern", path, n)
+    }
+    action := Action(fields[0])
+    if action != OPEN && action != URL && action != RUN {
+      return nil, fmt.Errorf("%s:%d: unknown action %s", path, n, fields[0])
+    }
+    pat, err := regexp.Compile(strings.TrimSpace(fields[1]))
+    if err != nil {
+      return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+    }
+    rules = append(rules, Rule{action, pat})
+  }
+  return rules, scanner.Err()
+}
+
+// Applies the first rule that matches text.
+func Plumb(rules []Rule, text string) (m Match, ok bool) {
+  text = strings.TrimSpace(text)
+  for _, r := range rules {
+    groups := r.Pattern.FindStringSubmatch(text)
+    if groups == nil {
+      continue
+    }
+    m = Match{Action: r.Action, Text: text}
+    if r.Action == OPEN {
+      m.Path = text
+      if len(groups) > 1 {
+        m.Path = groups[1]
+      }
+      if len(groups) > 2 {
+        m.Line, _ = strconv.Atoi(groups[2])
+      }
+      if len(groups) > 3 {
+        m.Col, _ = strconv.Atoi(groups[3])
+      }
+    }
+    return m, true
+  }
+  return
+}
@@ -0,0 +1,111 @@
+// A list of error locations picked out of compiler, vet, test and grep output.
+package quickfix
+
+import (
+  "bufio"
+  "io"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+type Entry struct {
+  Path string
+  Line, Col int
+  Text string
+}
+
+type List struct {
+  Entries []Entry
+  cur int
+}
+
+// Tried in order against each line of output.
+var formats = []*regexp.Regexp{
+  // go build, go vet and gcc: file:line:col: msg
+  regexp.MustCompile(`^([^:\s]+):(\d+):(\d+):\s*(.*)$`),
+  // go test, gcc without a column, and grep -n: file:line: msg
+  regexp.MustCompile(`^([^:\s]+):(\d+):\s*(.*)$`),
+}
+
+func New(entries []Entry) *List {
+  return &List{Entries: entries, cur: -1}
+}
+
+// Reads output line by line, keeping the lines that name a location.
+func Parse(r io.Reader) *List {
+  l := New(nil)
+  scanner := bufio.NewScanner(r)
+  for scanner.Scan() {
+    if e, ok := ParseLine(scanner.Text()); ok {
+      l.Entries = append(l.Entries, e)
+    }
+  }
+  return l
+}
+
+func ParseLine(line string) (e Entry, ok bool) {
+  // go test indents its messages
+  line = strings.TrimSpace(line)
+  for _, f := range formats {
+    groups := f.FindStringSubmatch(line)
+    if groups == nil {
+      continue
+    }
+    e.Path = groups[1]
+    e.Line, _ = strconv.Atoi(groups[2])
+    if len(groups) == 5 {
+      e.Col, _ = strconv.Atoi(groups[3])
+    }
+    e.Text = groups[len(groups) - 1]
+    return e, true
+  }
+  return
+}
+
+func (l *List) Len() int {
+  return len(l.Entries)
+}
+
+// The entry last moved to, if any.
+func (l *List) Current() (Entry, bool) {
+  if l.cur < 0 || l.cur >= len(l.Entries) {
+    return Entry{}, false
+  }
+  return l.Entries[l.cur], true
+}
+
+// Moves to the next entry, stopping at the last one.
+func (l *List) Next() (Entry, bool) {
+  if l.cur < len(l.Entries) - 1 {
+    l.cur++
+  }
+  return l.Current()
+}
+
+// Moves to the previous entry, stopping at the first one.
+func (l *List) Prev() (Entry, bool) {
+  if l.cur > 0 {
+    l.cur--
+  }
+  return l.Current()
+}
+
+// Lists the entries, marking the current one.
+func (l *List) String() string {
+  var builder strings.Builder
+  for i, e := range l.Entries {
+    mark := ' '
+    if i == l.cur {
+      mark = '>'
+    }
+    builder.WriteRune(mark)
+    builder.WriteString(strconv.Itoa(i + 1))
+    builder.WriteString(" " + e.Path + ":" + strconv.Itoa(e.Line))
+    if e.Col > 0 {
+      builder.WriteString(":" + strconv.Itoa(e.Col))
+    }
+    builder.WriteString(": " + e.Text + "\n")
+  }
+  return builder.String()
+}
@@ -13,8 +13,36 @@ const (
   NORMAL Style = 0
   UNDERLINE Style = iota
   HIGHLIGHT Style = iota
+  // for syntax highlighting
+  KEYWORD Style = iota
+  TYPE Style = iota
+  STRING Style = iota
+  COMMENT Style = iota
+  NUMBER Style = iota
+  CONSTANT Style = iota
+  HEADING Style = iota
+  ADDED Style = iota
+  REMOVED Style = iota
+  // for signs beside lines
+  ERROR Style = iota
+  WARNING Style = iota
+  INFO Style = iota
+  // for closed folds
+  FOLDED Style = iota
+  // for line numbers, and lines changed since a commit
+  GUTTER Style = iota
+  CHANGED Style = iota
 )
 
+// How each style is drawn.
+var styles = []string{
+  "\033[0m", "\033[0;4m", "\033[0;7m",
+  "\033[0;33m", "\033[0;36m", "\033[0;32m", "\033[0;34m", "\033[0;35m",
+  "\033[0;35m", "\033[0;1m", "\033[0;32m", "\033[0;31m",
+  "\033[0;1;31m", "\033[0;1;33m", "\033[0;1;34m",
+  "\033[0;36m", "\033[0;33m", "\033[0;34m",
+}
+
 type char struct {
   c rune 
   style Style
@@ -40,10 +68,49 @@ func New(rows, cols int) *Raster {
   return &Raster{rows, cols, chars, make([]bool, rows), 0, 0, bytes.Buffer{}}
 }
 
+func (r *Raster) Size() (rows, cols int) {
+  return r.rows, r.cols
+}
+
+// Puts c at the given location. Wide characters take the next cell too.
 func (r *Raster) Put(i, j int, c rune, style Style) {
   assertGraphic(c)
   r.dirty[i] = true
   r.chars[i][j] = char{c, style}
+  if Width(c) == 2 && j + 1 < r.cols {
+    // the terminal moves past this cell itself, so Read writes nothing for it
+    r.chars[i][j + 1] = char{0, style}
+  }
+}
+
+// How many columns c takes up on a terminal: 2 for the wide characters of
+// East Asian scripts, and 1 for anything else.
+func Width(c rune) int {
+  for _, r := range wide {
+    if c >= r[0] && c <= r[1] {
+      return 2
+    }
+  }
+  return 1
+}
+
+// Ranges of wide characters, most of them, anyway.
+var wide = [][2]rune{
+  {0x1100, 0x115F},
+  {0x2E80, 0x303E},
+  {0x3041, 0x33FF},
+  {0x3400, 0x4DBF},
+  {0x4E00, 0x9FFF},
+  {0xA000, 0xA4CF},
+  {0xAC00, 0xD7A3},
+  {0xF900, 0xFAFF},
+  {0xFE30, 0xFE4F},
+  {0xFF00, 0xFF60},
+  {0xFFE0, 0xFFE6},
+  {0x1F300, 0x1F64F},
+  {0x1F900, 0x1F9FF},
+  {0x20000, 0x2FFFD},
+  {0x30000, 0x3FFFD},
 }
 
 // Writes the string at the given location. The string is not wrapped.
@@ -95,6 +162,16 @@ func (r *Raster) ClearWith(b rune) {
   }
 }
 
+// Blanks the given rectangle, clipped to the raster.
+func (r *Raster) ClearRect(i, j, rows, cols int) {
+  for ii := i; ii < i + rows && ii < r.rows; ii++ {
+    r.dirty[ii] = true
+    for jj := j; jj < j + cols && jj < r.cols; jj++ {
+      r.chars[ii][jj] = char{' ', 0}
+    }
+  }
+}
+
 func (r *Raster) Clear() {
   r.ClearWith(' ')
 }
@@ -111,7 +188,6 @@ func (r *Raster) Read(buf []byte) (int, error) {
     return r.pending.Read(buf)
   }
 
-  styles := []string{"\033[0m", "\033[4m", "\033[7m"}
   prevStyle := Style(-1)
   anyDirty := false
   for i := 0; i < r.rows; i++ {
@@ -0,0 +1,187 @@
+// Registers, which hold text and recorded keys alike, kept across sessions in
+// a file.
+//
+// Besides the named registers a-z, there are the unnamed register ", which
+// gets everything yanked or deleted, 0, which gets the last yank, 1-9, which
+// get the last nine deletes, newest first, the read-only register /, which
+// has the last search, and +, which is copied to the terminal's clipboard.
+package register
+
+import (
+  "bufio"
+  "encoding/base64"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+const (
+  UNNAMED = '"'
+  SEARCH = '/'
+  CLIPBOARD = '+'
+)
+
+type Registers struct {
+  // where the clipboard escape is written, usually the terminal
+  Clipboard io.Writer
+  path string
+  named map[rune]string
+  search string
+  // whether anything has changed since the registers were loaded or saved
+  changed bool
+}
+
+// Reads registers saved in path by an earlier session. A missing file gives
+// empty registers. If the file can't be read, the registers read before the
+// problem are returned along with the error.
+func Load(path string) (*Registers, error) {
+  r := &Registers{path: path, named: map[rune]string{}}
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return r, nil
+  } else if err != nil {
+    return r, err
+  }
+  defer f.Close()
+  // registers can hold whole files, so lines are as long as they need to be
+  in := bufio.NewReader(f)
+  for n := 1; ; n++ {
+    line, err := in.ReadString('\n')
+    if err != nil && err != io.EOF {
+      return r, err
+    }
+    if line = strings.TrimRight(line, "\n"); line != "" {
+      name := []rune(line)[0]
+      s, qerr := strconv.Unquote(strings.TrimSpace(line[len(string(name)):]))
+      if qerr != nil || !Writable(name) {
+        return r, fmt.Errorf("%s:%d: expected a register and a quoted string", path, n)
+      }
+      r.named[name] = s
+    }
+    if err == io.EOF {
+      return r, nil
+    }
+  }
+}
+
+// Whether name is a register that can be read.
+func Valid(name rune) bool {
+  return Writable(name) || name == SEARCH
+}
+
+// Whether name is a register that can be set.
+func Writable(name rune) bool {
+  switch (name) {
+  case UNNAMED, CLIPBOARD:
+    return true
+  }
+  return name < unicode.MaxASCII && (unicode.IsLetter(name) || unicode.IsDigit(name))
+}
+
+func (r *Registers) Get(name rune) string {
+  if name == SEARCH {
+    return r.search
+  }
+  return r.named[unicode.ToLower(name)]
+}
+
+// Sets a register. Naming it in upper case appends to it instead.
+func (r *Registers) Set(name rune, s string) error {
+  if !Writable(name) {
+    return fmt.Errorf("can't set register %q", name)
+  }
+  if unicode.IsUpper(name) {
+    name = unicode.ToLower(name)
+    s = r.named[name] + s
+  }
+  r.named[name] = s
+  r.changed = true
+  if name == CLIPBOARD && r.Clipboard != nil {
+    // OSC 52, which terminals take as a request to set their clipboard, so it
+    // works from the far end of ssh too
+    fmt.Fprintf(r.Clipboard, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(s)))
+  }
+  return nil
+}
+
+// Keeps yanked text in the named register, or in 0 if there's no name, and
+// in the unnamed register either way.
+func (r *Registers) Yank(name rune, s string) error {
+  if name == 0 || name == UNNAMED {
+    name = '0'
+  }
+  if err := r.Set(name, s); err != nil {
+    return err
+  }
+  r.named[UNNAMED] = r.Get(name)
+  return nil
+}
+
+// Keeps deleted text in the named register, or at the front of 1-9 if there's
+// no name, and in the unnamed register either way.
+func (r *Registers) Delete(name rune, s string) error {
+  if name != 0 && name != UNNAMED {
+    return r.Yank(name, s)
+  }
+  for k := '9'; k > '1'; k-- {
+    r.named[k] = r.named[k - 1]
+  }
+  r.named['1'] = s
+  r.named[UNNAMED] = s
+  r.changed = true
+  return nil
+}
+
+// Remembers the last pattern searched for.
+func (r *Registers) SetSearch(s string) {
+  r.search = s
+}
+
+// Lists the registers that have something in them.
+func (r *Registers) String() string {
+  var builder strings.Builder
+  for _, name := range r.names() {
+    if r.named[name] != "" {
+      fmt.Fprintf(&builder, "%c %s\n", name, strconv.Quote(r.named[name]))
+    }
+  }
+  if r.search != "" {
+    fmt.Fprintf(&builder, "%c %s\n", SEARCH, strconv.Quote(r.search))
+  }
+  return builder.String()
+}
+
+func (r *Registers) names() []rune {
+  var names []rune
+  for name := range r.named {
+    names = append(names, name)
+  }
+  sort.Slice(names, func(a, b int) bool {
+    return names[a] < names[b]
+  })
+  return names
+}
+
+// Writes the registers out for the next session, if they have changed.
+func (r *Registers) Save() error {
+  if !r.changed {
+    return nil
+  }
+  var builder strings.Builder
+  for _, name := range r.names() {
+    fmt.Fprintf(&builder, "%c %s\n", name, strconv.Quote(r.named[name]))
+  }
+  if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+    return err
+  }
+  if err := os.WriteFile(r.path, []byte(builder.String()), 0600); err != nil {
+    return err
+  }
+  r.changed = false
+  return nil
+}
@@ -0,0 +1,42 @@
+package register
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "ged", "registers")
+  r, err := Load(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // more than a line's worth of anything line by line
+  big := strings.Repeat("a line of text\n", 1 << 17)
+  r.Yank('a', big)
+  r.Delete(0, "gone\n")
+  if err := r.Save(); err != nil {
+    t.Fatal(err)
+  }
+  r, err = Load(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if r.Get('a') != big || r.Get('1') != "gone\n" || r.Get(UNNAMED) != "gone\n" {
+    t.Errorf("registers came back as %.40q, %q, %q", r.Get('a'), r.Get('1'), r.Get(UNNAMED))
+  }
+}
+
+func TestBadFile(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "registers")
+  os.WriteFile(path, []byte("a \"kept\"\nb not quoted\n"), 0600)
+  r, err := Load(path)
+  if err == nil || !strings.Contains(err.Error(), ":2:") {
+    t.Errorf("got %v, want an error on line 2", err)
+  }
+  if r == nil || r.Get('a') != "kept" {
+    t.Errorf("lost the registers before the bad line")
+  }
+}
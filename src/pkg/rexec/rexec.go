@@ -31,3 +31,12 @@ func (r *RCmd) Start() error {
   return r.Session.Start(r.cmd)
 }
 
+// Runs the command and returns its standard output.
+func (r *RCmd) Output() ([]byte, error) {
+  return r.Session.Output(r.cmd)
+}
+
+// Runs the command and returns its standard output and error, interleaved.
+func (r *RCmd) CombinedOutput() ([]byte, error) {
+  return r.Session.CombinedOutput(r.cmd)
+}
@@ -1,10 +1,14 @@
 package rfs
 
 import (
+  "io"
   "io/fs"
   "path/filepath"
   "time"
   "fmt"
+  "bufio"
+  "bytes"
+  "strings"
   "golang.org/x/crypto/ssh"
 )
 
@@ -34,6 +38,106 @@ func (fs *RFS) Open(remotePath string) (fs.File, error) {
   return &RFile{RemotePath: remotePath, fs: fs}, nil
 }
 
+// Replaces the contents of remotePath with everything read from r.
+func (fs *RFS) WriteFile(remotePath string, r io.Reader) error {
+  session, err := fs.NewSession()
+  if err != nil {
+    return err
+  }
+  defer session.Close()
+  session.Stdin = r
+  return session.Run(fmt.Sprintf("cat > %s", Quote(remotePath)))
+}
+
+// Quotes s for the remote shell.
+func Quote(s string) string {
+  return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// Runs a command that should print nothing, turning any complaint into the error.
+func (fs *RFS) run(cmd string) error {
+  session, err := fs.NewSession()
+  if err != nil {
+    return err
+  }
+  defer session.Close()
+  out, err := session.CombinedOutput(cmd)
+  if err != nil && len(out) > 0 {
+    return fmt.Errorf("%s", bytes.TrimSpace(out))
+  }
+  return err
+}
+
+func (fs *RFS) Mkdir(remotePath string) error {
+  return fs.run("mkdir -- " + Quote(remotePath))
+}
+
+// Creates an empty file, leaving any existing one alone.
+func (fs *RFS) Create(remotePath string) error {
+  return fs.run(">> " + Quote(remotePath))
+}
+
+func (fs *RFS) Rename(oldPath, newPath string) error {
+  return fs.run("mv -- " + Quote(oldPath) + " " + Quote(newPath))
+}
+
+// Removes a file or an empty directory.
+func (fs *RFS) Remove(remotePath string) error {
+  return fs.run("if [ -d " + Quote(remotePath) + " ]; then rmdir -- " + Quote(remotePath) +
+    "; else rm -- " + Quote(remotePath) + "; fi")
+}
+
+// Lists a directory, following symlinks, sorted by name.
+func (r *RFS) ReadDir(remotePath string) ([]fs.DirEntry, error) {
+  session, err := r.NewSession()
+  if err != nil {
+    return nil, err
+  }
+  defer session.Close()
+  out, err := session.Output(fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 -exec stat -L --format=\"%%s %%f %%Y %%n\" {} + | sort -k 4",
+    Quote(remotePath)))
+  if err != nil {
+    return nil, err
+  }
+  var entries []fs.DirEntry
+  scanner := bufio.NewScanner(bytes.NewReader(out))
+  for scanner.Scan() {
+    fields := strings.SplitN(scanner.Text(), " ", 4)
+    if len(fields) != 4 {
+      continue
+    }
+    info := fileInfo{name: filepath.Base(fields[3]), fs: r}
+    var raw uint32
+    fmt.Sscanf(fields[0] + " " + fields[1] + " " + fields[2], "%d %x %d", &info.size, &raw, &info.modTimeSeconds)
+    info.mode = fileMode(raw)
+    entries = append(entries, fs.FileInfoToDirEntry(info))
+  }
+  return entries, scanner.Err()
+}
+
+// Converts a raw st_mode, as printed by stat's %f, to an fs.FileMode.
+func fileMode(raw uint32) fs.FileMode {
+  mode := fs.FileMode(raw & 0777)
+  switch (raw & 0170000) {
+  case 0040000: mode |= fs.ModeDir
+  case 0120000: mode |= fs.ModeSymlink
+  case 0010000: mode |= fs.ModeNamedPipe
+  case 0140000: mode |= fs.ModeSocket
+  case 0020000: mode |= fs.ModeDevice | fs.ModeCharDevice
+  case 0060000: mode |= fs.ModeDevice
+  }
+  if raw & 04000 != 0 {
+    mode |= fs.ModeSetuid
+  }
+  if raw & 02000 != 0 {
+    mode |= fs.ModeSetgid
+  }
+  if raw & 01000 != 0 {
+    mode |= fs.ModeSticky
+  }
+  return mode
+}
+
 func (f *RFile) Read(buf []byte) (int, error) {
   session, err := f.fs.NewSession()
   if err != nil {
@@ -44,7 +148,7 @@ func (f *RFile) Read(buf []byte) (int, error) {
   if err != nil {
     return 0, err
   }
-  err = session.Run(fmt.Sprintf("dd iflag=skip_bytes skip=%d count=1 if=%s", f.pos, f.RemotePath))
+  err = session.Run(fmt.Sprintf("dd iflag=skip_bytes skip=%d count=1 if=%s", f.pos, Quote(f.RemotePath)))
   if err != nil {
     return 0, err
   }
@@ -70,11 +174,13 @@ func (f *RFile) Stat() (fs.FileInfo, error) {
   if err != nil {
     return info, err
   }
-  err = session.Run(fmt.Sprintf("stat --format=\"%%s %%f %%Y\" %s", f.RemotePath))
+  err = session.Run(fmt.Sprintf("stat -L --format=\"%%s %%f %%Y\" %s", Quote(f.RemotePath)))
   if err != nil {
     return info, err
   }
-  _, err = fmt.Fscanf(r, "%d %x %d", &info.size, &info.mode, &info.modTimeSeconds)
+  var raw uint32
+  _, err = fmt.Fscanf(r, "%d %x %d", &info.size, &raw, &info.modTimeSeconds)
+  info.mode = fileMode(raw)
   return info, err 
 }
 
@@ -0,0 +1,79 @@
+package syntax
+
+import (
+  "strings"
+)
+
+// Used when there is no grammar file of the same name.
+var Default []*Grammar
+
+func init() {
+  for _, text := range grammars {
+    g, err := Parse(strings.NewReader(text), "default grammar")
+    if err != nil {
+      panic(err)
+    }
+    Default = append(Default, g)
+  }
+}
+
+var grammars = []string{`
+name go
+files *.go
+region comment /\* \*/
+match comment //.*
+region string ` + "` `" + `
+match string "(\\.|[^"\\])*"
+match string '(\\.|[^'\\])*'
+match keyword \b(break|case|chan|const|continue|default|defer|else|fallthrough|for|func|go|goto|if|import|interface|map|package|range|return|select|struct|switch|type|var)\b
+match type \b(bool|byte|complex64|complex128|error|float32|float64|int|int8|int16|int32|int64|rune|string|uint|uint8|uint16|uint32|uint64|uintptr|any)\b
+match constant \b(true|false|nil|iota)\b
+match number \b(0[xX][0-9a-fA-F_]+|[0-9][0-9_]*(\.[0-9_]*)?([eE][-+]?[0-9]+)?i?)\b
+`, `
+name shell
+files *.sh *.bash .bashrc .profile .bash_profile *.zsh
+shebang ^#!.*\b(ba|z|k|da)?sh\b
+match comment (^|\s)#.*
+match string '[^']*'
+match string "(\\.|[^"\\])*"
+match constant \$(\{[^}]*\}|[A-Za-z_][A-Za-z0-9_]*|[0-9#?$!@*-])
+match keyword \b(if|then|else|elif|fi|for|while|until|do|done|case|esac|in|function|return|local|export|readonly|shift|exit|break|continue)\b
+match number \b[0-9]+\b
+`, `
+name yaml
+files *.yaml *.yml
+match comment (^|\s)#.*
+match heading ^---$
+match keyword ^\s*(-\s+)?[^\s:#'"][^:#]*:(\s|$)
+match string '[^']*'
+match string "(\\.|[^"\\])*"
+match constant \b(true|false|null|yes|no|on|off)\b
+match number \b-?[0-9]+(\.[0-9]+)?\b
+match type [&*][A-Za-z0-9_-]+
+`, `
+name json
+files *.json .*rc.json
+match keyword "(\\.|[^"\\])*"\s*:
+match string "(\\.|[^"\\])*"
+match constant \b(true|false|null)\b
+match number -?\b[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?\b
+`, `
+name markdown
+files *.md *.markdown
+region string ^` + "```" + ` ^` + "```" + `$
+match heading ^#{1,6}\s.*
+match heading ^(=+|-+)\s*$
+match keyword ^\s*([-*+]|[0-9]+\.)\s
+match comment ^>.*
+match string ` + "`[^`]+`" + `
+match constant \[[^\]]*\]\([^)]*\)
+match type (\*\*|__)[^*_]+(\*\*|__)
+`, `
+name diff
+files *.diff *.patch
+match heading ^(diff|index|---|\+\+\+)\s.*
+match keyword ^@@.*@@
+match added ^\+.*
+match removed ^-.*
+match comment ^\\.*
+`}
@@ -0,0 +1,294 @@
+// Highlights text a line at a time, by grammars of regex rules.
+//
+// A grammar file has a line for each rule, and some lines about the grammar:
+//
+//   name go
+//   files *.go go.mod
+//   shebang ^#!.*\bgo\b
+//   match keyword \b(func|return|if|else)\b
+//   region comment /\* \*/
+//
+// Files are globs matched against the base name of a file, and shebang is
+// matched against its first line. Match rules give text matching a pattern a
+// style. Region rules give a style to everything from a begin pattern to an
+// end pattern, across lines; as the two are split by space, they can't hold
+// spaces themselves, but \s and \x20 do. Where rules match at the same place,
+// the first one wins. Blank lines and lines starting with # are skipped.
+//
+// The styles are keyword, type, string, comment, number, constant, heading,
+// added and removed.
+package syntax
+
+import (
+  "../raster"
+  "bufio"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "unicode/utf8"
+)
+
+var styles = map[string]raster.Style{
+  "keyword": raster.KEYWORD,
+  "type": raster.TYPE,
+  "string": raster.STRING,
+  "comment": raster.COMMENT,
+  "number": raster.NUMBER,
+  "constant": raster.CONSTANT,
+  "heading": raster.HEADING,
+  "added": raster.ADDED,
+  "removed": raster.REMOVED,
+}
+
+type Rule struct {
+  Style raster.Style
+  Pattern *regexp.Regexp
+  // where a region ends, or nil for rules that just match
+  End *regexp.Regexp
+  // the patterns matched from the rune before, to find matches partway
+  // along a line
+  after, endAfter *regexp.Regexp
+}
+
+type Grammar struct {
+  Name string
+  Files []string
+  Shebang *regexp.Regexp
+  Rules []Rule
+}
+
+// Where a line starts: 0 outside of any region, or one more than the index of
+// the region rule it starts in.
+type State int
+
+// Reads a grammar. Errors name the line they're on, and name.
+func Parse(r io.Reader, name string) (*Grammar, error) {
+  g := &Grammar{}
+  scanner := bufio.NewScanner(r)
+  for n := 1; scanner.Scan(); n++ {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    fields := strings.Fields(line)
+    rest := strings.TrimSpace(line[len(fields[0]):])
+    var err error
+    switch (fields[0]) {
+    case "name":
+      g.Name = rest
+    case "files":
+      g.Files = append(g.Files, fields[1:]...)
+    case "shebang":
+      g.Shebang, err = regexp.Compile(rest)
+    case "match", "region":
+      err = g.rule(fields[0] == "region", fields[1:])
+    default:
+      err = fmt.Errorf("unknown keyword %s", fields[0])
+    }
+    if err != nil {
+      return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+    }
+  }
+  if g.Name == "" {
+    return nil, fmt.Errorf("%s: grammar has no name", name)
+  }
+  return g, scanner.Err()
+}
+
+func (g *Grammar) rule(region bool, fields []string) error {
+  if len(fields) < 2 {
+    return fmt.Errorf("expected a style and a pattern")
+  }
+  style, ok := styles[fields[0]]
+  if !ok {
+    return fmt.Errorf("unknown style %s", fields[0])
+  }
+  var end *regexp.Regexp
+  if region {
+    if len(fields) != 3 {
+      return fmt.Errorf("expected a style, a begin pattern and an end pattern")
+    }
+    var err error
+    if end, err = regexp.Compile(fields[2]); err != nil {
+      return err
+    }
+  } else {
+    fields = []string{fields[0], strings.Join(fields[1:], " ")}
+  }
+  pattern, err := regexp.Compile(fields[1])
+  if err != nil {
+    return err
+  }
+  r := Rule{Style: style, Pattern: pattern, End: end, after: following(pattern)}
+  if end != nil {
+    r.endAfter = following(end)
+  }
+  g.Rules = append(g.Rules, r)
+  return nil
+}
+
+// A pattern matching one rune and then re, so that re's ^, \b and \B see the
+// rune before where it starts, as they would matching the whole line.
+func following(re *regexp.Regexp) *regexp.Regexp {
+  return regexp.MustCompile(`^(?s:.)(?:` + re.String() + `)`)
+}
+
+// Reads the grammars in the *.syntax files in dir, ahead of Default ones with
+// the same names. A missing dir gives just the Default grammars.
+func Load(dir string) ([]*Grammar, error) {
+  names, err := filepath.Glob(filepath.Join(dir, "*.syntax"))
+  if err != nil {
+    return nil, err
+  }
+  var grammars []*Grammar
+  loaded := map[string]bool{}
+  for _, name := range names {
+    contents, err := ioutil.ReadFile(name)
+    if os.IsNotExist(err) {
+      continue
+    } else if err != nil {
+      return nil, err
+    }
+    g, err := Parse(strings.NewReader(string(contents)), name)
+    if err != nil {
+      return nil, err
+    }
+    grammars = append(grammars, g)
+    loaded[g.Name] = true
+  }
+  for _, g := range Default {
+    if !loaded[g.Name] {
+      grammars = append(grammars, g)
+    }
+  }
+  return grammars, nil
+}
+
+// The grammar for the file called name, whose first line is given, or nil if
+// there isn't one.
+func Detect(grammars []*Grammar, name, first string) *Grammar {
+  base := path.Base(name)
+  for _, g := range grammars {
+    for _, glob := range g.Files {
+      if ok, _ := path.Match(glob, base); ok {
+        return g
+      }
+    }
+  }
+  if !strings.HasPrefix(first, "#!") {
+    return nil
+  }
+  for _, g := range grammars {
+    if g.Shebang != nil && g.Shebang.MatchString(first) {
+      return g
+    }
+  }
+  return nil
+}
+
+// The grammar called name, or nil.
+func Find(grammars []*Grammar, name string) *Grammar {
+  for _, g := range grammars {
+    if g.Name == name {
+      return g
+    }
+  }
+  return nil
+}
+
+// Styles each rune of line, a line without its newline starting in state,
+// and returns the state the next line starts in.
+func (g *Grammar) Line(line string, state State) ([]raster.Style, State) {
+  styles := make([]raster.Style, len(line))
+  fill := func(from, to int, style raster.Style) {
+    for k := from; k < to; k++ {
+      styles[k] = style
+    }
+  }
+  pos := 0
+  if state > 0 {
+    r := g.Rules[state - 1]
+    m := r.End.FindStringIndex(line)
+    if m == nil {
+      fill(0, len(line), r.Style)
+      return runes(line, styles), state
+    }
+    fill(0, m[1], r.Style)
+    pos, state = m[1], 0
+  }
+  // where each rule next matches, found again once we're past it
+  next := make([][]int, len(g.Rules))
+  for pos < len(line) {
+    best := -1
+    for k, r := range g.Rules {
+      if next[k] == nil || next[k][0] >= 0 && next[k][0] < pos {
+        next[k] = find(r.Pattern, r.after, line, pos)
+      }
+      if next[k][0] >= 0 && (best < 0 || next[k][0] < next[best][0]) {
+        best = k
+      }
+    }
+    if best < 0 {
+      break
+    }
+    m, r := next[best], g.Rules[best]
+    if r.End == nil {
+      fill(m[0], m[1], r.Style)
+    } else if end := find(r.End, r.endAfter, line, m[1]); end[0] >= 0 {
+      fill(m[0], end[1], r.Style)
+      m = []int{m[0], end[1]}
+    } else {
+      fill(m[0], len(line), r.Style)
+      return runes(line, styles), State(best + 1)
+    }
+    pos = m[1]
+    if m[1] == m[0] {
+      pos++
+    }
+  }
+  return runes(line, styles), state
+}
+
+// The next match of re in line at or after pos, or {-1, -1}, matched as if
+// against the whole line. Searching line[pos:] finds matches after its start
+// as they would be found in line, but one at its start could be there only
+// because ^ or \b took it for the start of the line; after checks for a match
+// there with the rune before in view instead.
+func find(re, after *regexp.Regexp, line string, pos int) []int {
+  for pos <= len(line) {
+    if pos > 0 {
+      _, size := utf8.DecodeLastRuneInString(line[:pos])
+      if m := after.FindStringIndex(line[pos - size:]); m != nil {
+        return []int{pos, pos - size + m[1]}
+      }
+    }
+    m := re.FindStringIndex(line[pos:])
+    if m == nil {
+      return []int{-1, -1}
+    } else if pos == 0 || m[0] > 0 {
+      return []int{m[0] + pos, m[1] + pos}
+    } else if pos == len(line) {
+      break
+    }
+    _, size := utf8.DecodeRuneInString(line[pos:])
+    pos += size
+  }
+  return []int{-1, -1}
+}
+
+// Turns the style of each byte of line into the style of each rune.
+func runes(line string, styles []raster.Style) []raster.Style {
+  if utf8.RuneCountInString(line) == len(line) {
+    return styles
+  }
+  out := make([]raster.Style, 0, len(line))
+  for k := range line {
+    out = append(out, styles[k])
+  }
+  return out
+}
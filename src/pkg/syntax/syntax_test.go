@@ -0,0 +1,33 @@
+package syntax
+
+import (
+  "../raster"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestMatchesSeeTheLine(t *testing.T) {
+  g, err := Parse(strings.NewReader("name t\nmatch string '[^']*'\nmatch comment (^|\\s)#.*\nmatch keyword \\bif\\b\n"), "t")
+  if err != nil {
+    t.Fatal(err)
+  }
+  S, C, K, N := raster.STRING, raster.COMMENT, raster.KEYWORD, raster.Style(0)
+  for _, test := range []struct {
+    line string
+    want []raster.Style
+  }{
+    {"# c", []raster.Style{C, C, C}},
+    {"a #c", []raster.Style{N, C, C, C}},
+    // a comment rule that first matched inside the string is looked for
+    // again after it, where # follows a quote and not a space
+    {"' #'#c", []raster.Style{S, S, S, S, N, N}},
+    {"' #' #c", []raster.Style{S, S, S, S, C, C, C}},
+    {"' if'if", []raster.Style{S, S, S, S, S, K, K}},
+  } {
+    got, _ := g.Line(test.line, 0)
+    if !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%q styled %v, want %v", test.line, got, test.want)
+    }
+  }
+}